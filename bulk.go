@@ -0,0 +1,83 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+// BulkLoad creates a hash map holding the items keys[i]/vals[i]. The table is sized for the items upfront (see LogSizeFor),
+// and items are placed in the order of their first candidate bucket, so that memory is written mostly sequentially;
+// only the few items which find no free cell go through the random walk of Insert. This is somewhat faster than inserting
+// the items one by one into a table of the same size, at the cost of a temporary sorted copy of the items and their hashes.
+// If a key occurs more than once, the last item wins, as with Insert.
+func BulkLoad(keys []Key, vals []Value) *Cuckoo {
+	if len(vals) < len(keys) {
+		panic("cuckoo: BulkLoad needs a value for every key")
+	}
+
+	c := NewCuckoo(LogSizeFor(len(keys)))
+
+	// counting sort of the items by their first candidate bucket; it is stable, so items keep their order within a bucket.
+	type pending struct {
+		h [nhash]hash
+		k Key
+		v Value
+	}
+	start := make([]int, len(c.buckets)+1)
+	hs := make([][nhash]hash, len(keys)) // hashes of the keys, computed once for both passes.
+	for i, k := range keys {
+		c.dohash(k, &hs[i])
+		start[hs[i][0]+1]++
+	}
+	for b := 1; b < len(start); b++ {
+		start[b] += start[b-1]
+	}
+	sorted := make([]pending, len(keys))
+	next := append([]int(nil), start[:len(c.buckets)]...)
+	for i, k := range keys {
+		b := hs[i][0]
+		sorted[next[b]] = pending{h: hs[i], k: k, v: vals[i]}
+		next[b]++
+	}
+	hs = nil
+
+	var overflow []pending
+	for b := range c.buckets {
+		group := sorted[start[b]:start[b+1]]
+	items:
+		for j := range group {
+			p := &group[j]
+			// a later item with the same key (hence in the same group) wins.
+			for _, later := range group[j+1:] {
+				if later.k == p.k {
+					continue items
+				}
+			}
+
+			switch {
+			case p.k == 0:
+				c.Insert(p.k, p.v)
+			case c.tryAdd(p.k, p.v, &p.h, false, 0):
+				c.nentries++
+				c.version++ // as Insert does, so that the metadata tells a loaded table from an empty one.
+			default:
+				overflow = append(overflow, *p)
+			}
+		}
+	}
+
+	for _, p := range overflow {
+		c.Insert(p.k, p.v)
+	}
+	return c
+}
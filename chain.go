@@ -0,0 +1,86 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+// Displacement chain statistics.
+// The chain length of an insertion is the number of items the random walk evicted to make room for the new item.
+// Chains get longer as the table fills up, well before insertions start to fail and trigger a grow.
+
+// recordChain records the chain length of an insertion.
+func (c *Cuckoo) recordChain(n int) {
+	c.chains[n]++
+	if n > c.maxChain {
+		c.maxChain = n
+	}
+
+	c.nchains++
+	if c.nchains < chainCheck {
+		return
+	}
+	c.nchains = 0
+
+	if c.chainAlarm != nil {
+		p99 := c.ChainPercentile(0.99)
+		if p99 > c.chainLimit && !c.chainHigh {
+			c.chainAlarm(p99)
+		}
+		c.chainHigh = p99 > c.chainLimit
+	}
+
+	// decay the histogram every chainDecay checks.
+	total := 0
+	for _, count := range &c.chains {
+		total += count
+	}
+	if total >= chainCheck*chainDecay {
+		for i := range &c.chains {
+			c.chains[i] /= 2
+		}
+	}
+}
+
+// ChainPercentile returns the p-th quantile (0 <= p <= 1) of the displacement chain lengths of recent insertions.
+func (c *Cuckoo) ChainPercentile(p float64) int {
+	total := 0
+	for _, count := range &c.chains {
+		total += count
+	}
+
+	rank := int(p * float64(total))
+	for n, count := range &c.chains {
+		rank -= count
+		if rank < 0 {
+			return n
+		}
+	}
+
+	return c.maxChain
+}
+
+// MaxChain returns the length of the longest displacement chain seen so far.
+func (c *Cuckoo) MaxChain() int {
+	return c.maxChain
+}
+
+// SetChainAlarm registers f to be called when the 99th percentile of the displacement chain lengths of recent insertions exceeds limit.
+// This signals that the table is approaching saturation, typically well before an insertion fails and triggers a grow.
+// f is called once when the percentile goes over the limit, and again only after it has dropped back.
+// Passing a nil f removes the alarm.
+func (c *Cuckoo) SetChainAlarm(limit int, f func(p99 int)) {
+	c.chainLimit = limit
+	c.chainAlarm = f
+	c.chainHigh = false
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Command cuckoo manages hash maps encoded with Cuckoo.WriteTo.
+//
+// Usage:
+//
+//	cuckoo merge -o out a b...   merge the items of a, b, ... into out; later files win for duplicate keys.
+//	cuckoo diff a b              list the items which differ between a and b; exits with status 1 if there are any.
+//	cuckoo bench [-keys n]       insert and search n random keys, and report the load factor, memory and throughput achieved.
+//
+// diff prints one line per item: "-" for keys only in a, "+" for keys only in b, and "~" for keys whose values differ.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/salviati/cuckoo"
+)
+
+// errDiffer makes diff exit with status 1, like diff(1).
+var errDiffer = errors.New("files differ")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	switch {
+	case err == errDiffer:
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "cuckoo:", err)
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: cuckoo merge|diff|bench ...")
+	}
+
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "merge":
+		return merge(args)
+	case "diff":
+		return diff(args, stdout)
+	case "bench":
+		return bench(args, stdout)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func load(c *cuckoo.Cuckoo, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := c.ReadFrom(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func merge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("o", "", "output `file`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" || fs.NArg() == 0 {
+		return errors.New("usage: cuckoo merge -o out a b...")
+	}
+
+	c := cuckoo.NewCuckoo(cuckoo.DefaultLogSize)
+	for _, path := range fs.Args() {
+		if err := load(c, path); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := c.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func diff(args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return errors.New("usage: cuckoo diff a b")
+	}
+
+	a, b := cuckoo.NewCuckoo(cuckoo.DefaultLogSize), cuckoo.NewCuckoo(cuckoo.DefaultLogSize)
+	if err := load(a, args[0]); err != nil {
+		return err
+	}
+	if err := load(b, args[1]); err != nil {
+		return err
+	}
+
+	type line struct {
+		k  cuckoo.Key
+		op byte
+		v  []cuckoo.Value
+	}
+	var lines []line
+	a.ForRange(func(k cuckoo.Key, v cuckoo.Value) {
+		if bv, ok := b.Search(k); !ok {
+			lines = append(lines, line{k, '-', []cuckoo.Value{v}})
+		} else if !reflect.DeepEqual(bv, v) {
+			lines = append(lines, line{k, '~', []cuckoo.Value{v, bv}})
+		}
+	})
+	b.ForRange(func(k cuckoo.Key, v cuckoo.Value) {
+		if _, ok := a.Search(k); !ok {
+			lines = append(lines, line{k, '+', []cuckoo.Value{v}})
+		}
+	})
+	if len(lines) == 0 {
+		return nil
+	}
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i].k < lines[j].k })
+	w := bufio.NewWriter(stdout)
+	for _, l := range lines {
+		fmt.Fprintf(w, "%c %v", l.op, l.k)
+		for _, v := range l.v {
+			fmt.Fprintf(w, " %v", v)
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return errDiffer
+}
+
+func bench(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	n := fs.Int("keys", 1e6, "number of `keys` to insert")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *n <= 0 || fs.NArg() != 0 {
+		return errors.New("usage: cuckoo bench [-keys n]")
+	}
+
+	keys := make([]cuckoo.Key, *n)
+	for i := range keys {
+		keys[i] = cuckoo.Key(rand.Uint64())
+	}
+
+	var v cuckoo.Value
+	c := cuckoo.NewCuckoo(cuckoo.DefaultLogSize)
+	start := time.Now()
+	for _, k := range keys {
+		c.Insert(k, v)
+	}
+	insert := time.Since(start)
+
+	start = time.Now()
+	for _, k := range keys {
+		c.Search(k)
+	}
+	search := time.Since(start)
+
+	s := c.Stats()
+	cell := unsafe.Sizeof(cuckoo.Key(0)) + unsafe.Sizeof(v)
+	fmt.Fprintf(stdout, "items        %d\n", s.Len)
+	fmt.Fprintf(stdout, "cells        %d\n", s.Cap)
+	fmt.Fprintf(stdout, "load factor  %.3f\n", s.LoadFactor)
+	fmt.Fprintf(stdout, "memory       %d bytes (%.1f per item)\n", uintptr(s.Cap)*cell, float64(uintptr(s.Cap)*cell)/float64(s.Len))
+	fmt.Fprintf(stdout, "grows        %d\n", s.Grows)
+	fmt.Fprintf(stdout, "insert       %.0f ops/s\n", float64(*n)/insert.Seconds())
+	fmt.Fprintf(stdout, "search       %.0f ops/s\n", float64(*n)/search.Seconds())
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salviati/cuckoo"
+)
+
+func save(t *testing.T, path string, items map[cuckoo.Key]cuckoo.Value) {
+	c := cuckoo.NewCuckoo(cuckoo.DefaultLogSize)
+	for k, v := range items {
+		c.Insert(k, v)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := c.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cuckoo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, b, out := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "out")
+	save(t, a, map[cuckoo.Key]cuckoo.Value{1: 1, 2: 2, 3: 3})
+	save(t, b, map[cuckoo.Key]cuckoo.Value{2: 2, 3: 30, 4: 4})
+
+	var stdout bytes.Buffer
+	if err := run([]string{"diff", a, b}, &stdout); err != errDiffer {
+		t.Error("got: ", err, " expected: ", errDiffer)
+	}
+	if got, want := stdout.String(), "- 1 1\n~ 3 3 30\n+ 4 4\n"; got != want {
+		t.Errorf("got: %q expected: %q", got, want)
+	}
+
+	if err := run([]string{"merge", "-o", out, a, b}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "want")
+	save(t, want, map[cuckoo.Key]cuckoo.Value{1: 1, 2: 2, 3: 30, 4: 4})
+	stdout.Reset()
+	if err := run([]string{"diff", out, want}, &stdout); err != nil || stdout.Len() != 0 {
+		t.Error("merge result differs: ", err, stdout.String())
+	}
+}
+
+func TestBench(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := run([]string{"bench", "-keys", "10000"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "load factor") {
+		t.Error("unexpected report: ", stdout.String())
+	}
+}
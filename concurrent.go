@@ -0,0 +1,169 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Concurrent is a thread-safe hash map made of independent Cuckoo tables (shards), each guarded by its own lock.
+// A key always belongs to the same shard, which is chosen by a hash of the key independent of the hashes used within the shards.
+// Hence, operations on different shards proceed in parallel, and a grow only blocks the shard being grown.
+//
+// This is lock striping rather than the fine-grained bucket locking of libcuckoo: the random walk of an insertion
+// never crosses shards, so it needs no locks beyond that of its shard.
+type Concurrent struct {
+	shards []shard
+	shift  uint // a key belongs to shards[hash>>shift].
+	seed   hash
+}
+
+type shard struct {
+	sync.RWMutex
+	c *Cuckoo
+	_ [64]byte // keep locks of neighbouring shards on separate cache lines.
+}
+
+// NewConcurrent creates a new thread-safe hash map with 2^logsize number of key/value cells initially, spread over the shards.
+// The number of shards is the smallest power of 2 which is at least 4*GOMAXPROCS.
+func NewConcurrent(logsize int) *Concurrent {
+	return NewConcurrentShards(logsize, 4*runtime.GOMAXPROCS(0))
+}
+
+// NewConcurrentShards is like NewConcurrent, but with the number of shards rounded up to a power of 2 from nshards (at most 1<<16).
+// Fewer shards mean fewer, larger tables and less memory overhead; more shards mean less contention, which write-heavy
+// workloads need. A single shard amounts to a Cuckoo guarded by one lock.
+func NewConcurrentShards(logsize, nshards int) *Concurrent {
+	shardshift := 0
+	for 1<<uint(shardshift) < nshards && shardshift < 16 {
+		shardshift++
+	}
+
+	m := &Concurrent{
+		shards: make([]shard, 1<<uint(shardshift)),
+		shift:  uint(hashBits - shardshift),
+		seed:   randomSeed(),
+	}
+	for i := range m.shards {
+		m.shards[i].c = NewCuckoo(logsize - shardshift)
+	}
+
+	return m
+}
+
+func (m *Concurrent) shard(k Key) *shard {
+	return &m.shards[defaultHash(k, m.seed)>>m.shift]
+}
+
+// Search tries to retrieve the value associated with the given key.
+// If no such item is found, ok is set to false.
+func (m *Concurrent) Search(k Key) (v Value, ok bool) {
+	s := m.shard(k)
+	s.RLock()
+	v, ok = s.c.Search(k)
+	s.RUnlock()
+	return
+}
+
+// Insert adds given key/value item into the hash map.
+// If an item with key k already exists, it will be replaced.
+func (m *Concurrent) Insert(k Key, v Value) {
+	s := m.shard(k)
+	s.Lock()
+	s.c.Insert(k, v)
+	s.Unlock()
+}
+
+// Upsert sets the value associated with the given key to f(old, exists), see Cuckoo.Upsert.
+// The read-modify-write happens under a single lock acquisition; f must not access the hash map.
+func (m *Concurrent) Upsert(k Key, f func(old Value, exists bool) Value) {
+	s := m.shard(k)
+	s.Lock()
+	s.c.Upsert(k, f)
+	s.Unlock()
+}
+
+// GetOrInsert returns the value associated with the given key if there is one, or inserts v otherwise, see Cuckoo.GetOrInsert.
+func (m *Concurrent) GetOrInsert(k Key, v Value) (actual Value, loaded bool) {
+	s := m.shard(k)
+	s.Lock()
+	actual, loaded = s.c.GetOrInsert(k, v)
+	s.Unlock()
+	return
+}
+
+// Delete removes the item corresponding to the given key (if exists).
+func (m *Concurrent) Delete(k Key) {
+	s := m.shard(k)
+	s.Lock()
+	s.c.Delete(k)
+	s.Unlock()
+}
+
+// Len returns the number of items in the hash map.
+// Shards are counted one after the other, so the result may be off under concurrent modification.
+func (m *Concurrent) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.RLock()
+		n += s.c.Len()
+		s.RUnlock()
+	}
+	return n
+}
+
+// ForRange loops over all (key,value) pairs in the hash map and calls f for each.
+// Each shard is read-locked while it is visited, so f must not modify the hash map.
+func (m *Concurrent) ForRange(f func(Key, Value)) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.RLock()
+		s.c.ForRange(f)
+		s.RUnlock()
+	}
+}
+
+// SnapshotTo writes an image of the hash map to w, in the format of Cuckoo.WriteTo.
+// Shards are copied one after the other (see Cuckoo.Clone), each read-locked only while its table is copied, so other
+// goroutines keep modifying the hash map during the snapshot, and at most one extra shard is held in memory at a time.
+// Each shard is captured at a single point in time, but different shards at different ones, as with Len.
+// The metadata is that of the shards combined: the earliest creation time, the sum of their versions, and all labels.
+func (m *Concurrent) SnapshotTo(w io.Writer) (int64, error) {
+	var items []item
+	meta := Meta{Labels: make(map[string]string)}
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.RLock()
+		c := s.c.Clone()
+		s.RUnlock()
+
+		c.ForRange(func(k Key, v Value) {
+			items = append(items, item{k, v})
+		})
+		if meta.Created.IsZero() || c.created.Before(meta.Created) {
+			meta.Created = c.created
+		}
+		meta.Version += c.version
+		for k, v := range c.labels {
+			meta.Labels[k] = v
+		}
+	}
+	sortItems(items)
+	return writeItems(w, items, &meta)
+}
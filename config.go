@@ -21,17 +21,28 @@ const (
 	nhashshift            = 2   // Number of hash functions is 1<<nhashshift. (With SSE2, we can do 4 at once).
 	shrinkFactor          = 0   // A shrink will be triggered when the load factor goes below 2^(-shrinkFactor). Setting this to 0 will disable shrinking and avoid potential new allocations.
 	rehashThreshold       = 0.9 // If the load factor is below rehashThreshold, Insert will try to rehash everything before actually growing.
+	floodThreshold        = 0.5 // An insertion failing below this load factor is taken as a sign of hash flooding (see SetFloodHandler).
 	randomWalkCoefficient = 2   // A multiplicative coefficient best determined by benchmarks. The optimal value depends on bshift and nhashshift.
 	stashSize             = 4   // Size of stash (see Kirsch, Adam, Michael Mitzenmacher, and Udi Wieder. "More robust hashing: Cuckoo hashing with a stash." SIAM Journal on Computing 39.4 (2009): 1543-1561.)
+	localityShift         = 0   // If non-zero, all candidate buckets of a key lie within the same block of 1<<localityShift buckets, so that a lookup touches a single page of memory (a bucket of uint32 keys/values is 64 bytes, so 6 means 4 KiB). Costs some load factor. 0 disables it.
 )
 
 // other configurable variables
 const (
 	gc             = false      // trigger GC after every alloc (which happens during grow).
+	instrument     = false      // record latency histograms of Insert, Search and Delete (see Stats). Costs two time.Now calls per operation.
+	stringBuckets  = 16         // String prints at most this many non-empty buckets; use DumpTo for the whole table.
+	chainCheck     = 1 << 10    // The chain length alarm (see SetChainAlarm) is checked after this many insertions.
+	chainDecay     = 1 << 6     // The chain length histogram is halved once it holds chainCheck*chainDecay insertions, so that it reflects recent ones.
+	overflowShift  = 4          // The secondary table of an Overflow starts at 2^-overflowShift times the size limit of the primary one.
+	rateSample     = 1 << 10    // The growth rate (see ForecastFullIn) is sampled after this many insertions and deletions.
+	rateSmoothing  = 0.2        // Weight of the latest sample in the exponentially weighted moving average of the growth rate.
+	skewMin        = 1 << 6     // The skew handler (see SetSkewHandler) ignores hash functions which place fewer items than this.
+	parallelSort   = 1 << 16    // WriteTo sorts the items on several goroutines from this many items on (see sortItems).
 	DefaultLogSize = 8 + bshift // A reasonable logsize value for NewCuckoo for use when the number of items to be inserted is not known ahead.
 )
 
-// Key must be an integer-type.
+// Key must be an integer-type, at most 64 bits wide. All bits of the key are hashed.
 type Key uint32
 
 // Value can be anything, replace this to match your needs (not using unsafe.Pointer to avoid the overhead to store additional pointer or interface{} which comes with a worse overhead).
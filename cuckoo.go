@@ -19,8 +19,12 @@
 package cuckoo
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
 	"math/rand"
 	"runtime"
+	"time"
+	"unsafe"
 )
 
 const (
@@ -49,6 +53,7 @@ type Cuckoo struct {
 	ngrow    int
 	nshrink  int
 	nrehash  int
+	nkicks   int // number of items evicted by random walks.
 	// To avoid allocating a bitmap for bucket usage, we use the default value of key (which is 0) to indicate that the entry is not used.
 	// Instead of forbidding items with key==0 (and exposing an implementation quirk to the user), we use zeroValue and zeroIsSet to store
 	// an item with 0 key. Hence, there is no key/value with key==0 within buckets and any bucket with key==0 is empty.
@@ -58,9 +63,63 @@ type Cuckoo struct {
 	eitem     bool  // evacuated leftover item,
 	ekey      Key   // ...and its key.
 	eval      Value
-	seed      [nhash]hash // seed for hash functions.
+	epath     [maxWalk]int // cells visited by the last random walk, used to undo it.
+	elen      int          // length of epath.
+	seed      [nhash]hash  // seed for hash functions.
+	hashfn    HashFunc     // if nil, defaultHash is used.
+
+	maxLogsize int       // if non-zero, the table never grows beyond 1<<maxLogsize buckets.
+	admission  Admission // what Insert does when the table is full and cannot grow.
+	nrejected  int       // number of items dropped due to maxLogsize.
+	onEvict    func(Key, Value)
+	nflood     int // number of insertions which failed at a suspiciously low load factor.
+	onFlood    func(load float64)
+	onGrow     func(oldCap, newCap int) bool
+
+	chains     [maxWalk + 1]int // histogram of displacement chain lengths of recent insertions.
+	nchains    int              // number of insertions recorded in chains since the last check.
+	maxChain   int              // longest chain seen so far.
+	chainLimit int              // chainAlarm fires when the 99th percentile of chains exceeds this...
+	chainAlarm func(p99 int)
+	chainHigh  bool // ...and only once, until it drops back.
+
+	skewRatio float64 // onSkew fires when a hash function places this many times fewer items than the next one, see SetSkewHandler.
+	onSkew    func(counts [NumHash]int)
+
+	lat *latencies // allocated only if instrument is set.
+
+	nticks   int       // number of insertions and deletions, for sampling the growth rate.
+	rateTime time.Time // time and length at the last sample.
+	rateLen  int
+	rate     float64 // moving average of the growth rate, in items per second.
+
+	created time.Time // see Meta.
+	version uint64
+	labels  map[string]string
 }
 
+// Admission is the policy Insert follows when an item cannot be placed and the table has reached its size limit.
+type Admission int
+
+const (
+	// RejectNew leaves the table as it was before the Insert call, dropping the new item.
+	RejectNew Admission = iota
+	// EvictRandom keeps the new item and drops the item left over after the random walk instead,
+	// which is a randomly chosen old item (or, rarely, the new item itself).
+	EvictRandom
+)
+
+// HashFunc is a seeded hash function of a key, see SetHash.
+// Different seeds must give independent hash functions, and all bits of the result must be well mixed.
+type HashFunc func(k Key, seed uint32) uint32
+
+// NumHash is the number of hash functions, hence the number of candidate buckets of a key.
+const NumHash = nhash
+
+const searchBatch = 64 // number of keys SearchBatch hashes at once; SearchBitmap relies on it being 64.
+
+const maxWalk = (hashBits + 1) * randomWalkCoefficient // upper bound for the number of steps in tryGreedyAdd.
+
 var zero Value
 
 func alloc(n int) []bucket {
@@ -81,7 +140,7 @@ func init() {
 // NewCuckoo creates a new cuckoo hash table with 2^logsize number of key/value cells initially.
 //
 // If you can estimate the number of unique items n (unique here refers to keys, not values) you are going to insert,
-// choosing a proper logsize [which is math.Ceil(math.Log2(n))] here is strongly advised.
+// choosing a proper logsize [see LogSizeFor] here is strongly advised.
 // Doing so will avoid grows, which are computationally expensive and require allocation.
 
 // Logsize mustn't exceed hashBits, defined in hash.go.
@@ -99,6 +158,11 @@ func NewCuckoo(logsize int) *Cuckoo {
 	c := &Cuckoo{
 		buckets: alloc(1 << uint(logsize)),
 		logsize: logsize,
+		created: time.Now(),
+	}
+	if instrument {
+		// allocated upfront rather than on first use, as Concurrent may record latencies of a shard in parallel.
+		c.lat = &latencies{}
 	}
 
 	c.reseed()
@@ -106,10 +170,38 @@ func NewCuckoo(logsize int) *Cuckoo {
 	return c
 }
 
+// LogSizeFor returns the smallest logsize (for NewCuckoo or SetMaxLogSize) whose table holds n items
+// at a load factor of at most rehashThreshold (see config.go), which insertions reach reliably.
+func LogSizeFor(n int) int {
+	logsize := bshift + 1
+	for float64(uint64(1)<<uint(logsize))*rehashThreshold < float64(n) {
+		logsize++
+	}
+	return logsize
+}
+
+// TableBytes returns the memory taken by the table of a hash map of the given logsize, excluding the stash.
+func TableBytes(logsize int) int {
+	if logsize <= bshift {
+		logsize = bshift + 1
+	}
+	return int(unsafe.Sizeof(bucket{})) << uint(logsize-bshift)
+}
+
 func (c *Cuckoo) reseed() {
 	for i := range &c.seed {
-		c.seed[i] = hash(rand.Uint32())
+		c.seed[i] = randomSeed()
+	}
+}
+
+// randomSeed returns an unpredictable seed, so that keys cannot be crafted to collide (and placements differ across processes,
+// whatever the seed of math/rand is).
+func randomSeed() hash {
+	var b [4]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return hash(rand.Uint32())
 	}
+	return hash(binary.LittleEndian.Uint32(b[:]))
 }
 
 // Len returns the number of items in the hash map.
@@ -119,14 +211,151 @@ func (c *Cuckoo) Len() int {
 
 // default hash function
 func defaultHash(k Key, seed hash) hash {
-	return hash(xx_32(uint32(k), uint32(seed)))
+	h := xx_32(uint32(k), uint32(seed))
+	if unsafe.Sizeof(k) > 4 {
+		// fold in the upper half of 64-bit keys, otherwise keys differing only there would always collide.
+		h = xx_32(uint32(uint64(k)>>32), h)
+	}
+	return hash(h)
 }
 
 func (c *Cuckoo) dohash(key Key, h *[nhash]hash) {
 	mask := hash((1 << uint(c.logsize)) - 1)
 
-	for i := range h {
-		h[i] = defaultHash(key, c.seed[i]) & mask
+	if c.hashfn != nil {
+		for i := range h {
+			h[i] = hash(c.hashfn(key, uint32(c.seed[i]))) & mask
+		}
+	} else {
+		for i := range h {
+			h[i] = defaultHash(key, c.seed[i]) & mask
+		}
+	}
+
+	c.locality(h)
+	return
+}
+
+func (c *Cuckoo) locality(h *[nhash]hash) {
+	if localityShift > 0 && c.logsize > localityShift {
+		// keep the block of the first bucket, pick the rest within that block.
+		const lmask hash = 1<<localityShift - 1
+		for i := 1; i < nhash; i++ {
+			h[i] = h[0]&^lmask | h[i]&lmask
+		}
+	}
+}
+
+// dohashBatch is dohash for many keys. Keys are processed 4 at a time, giving the CPU independent work to overlap.
+func (c *Cuckoo) dohashBatch(keys []Key, h [][nhash]hash) {
+	mask := hash((1 << uint(c.logsize)) - 1)
+
+	i := 0
+	for ; i+4 <= len(keys) && c.hashfn == nil; i += 4 {
+		k0, k1, k2, k3 := keys[i], keys[i+1], keys[i+2], keys[i+3]
+		h0, h1, h2, h3 := &h[i], &h[i+1], &h[i+2], &h[i+3]
+		for j, seed := range &c.seed {
+			h0[j] = defaultHash(k0, seed) & mask
+			h1[j] = defaultHash(k1, seed) & mask
+			h2[j] = defaultHash(k2, seed) & mask
+			h3[j] = defaultHash(k3, seed) & mask
+		}
+		c.locality(h0)
+		c.locality(h1)
+		c.locality(h2)
+		c.locality(h3)
+	}
+
+	for ; i < len(keys); i++ {
+		c.dohash(keys[i], &h[i])
+	}
+}
+
+// HashBatch computes the candidate buckets of every key in keys; those of keys[i] are stored in out[i*NumHash:(i+1)*NumHash].
+// out must have room for len(keys)*NumHash indices. The result is valid until the next modification of the hash map.
+func (c *Cuckoo) HashBatch(keys []Key, out []int) {
+	var h [searchBatch][nhash]hash
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > searchBatch {
+			n = searchBatch
+		}
+
+		c.dohashBatch(keys[:n], h[:n])
+		for i := range h[:n] {
+			for j, hval := range &h[i] {
+				out[i*nhash+j] = int(hval)
+			}
+		}
+
+		keys = keys[n:]
+		out = out[n*nhash:]
+	}
+}
+
+// SearchBatch is Search for many keys: vals[i], ok[i] are set to the result of Search(keys[i]).
+// vals and ok must be at least as long as keys.
+func (c *Cuckoo) SearchBatch(keys []Key, vals []Value, ok []bool) {
+	var h [searchBatch][nhash]hash
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > searchBatch {
+			n = searchBatch
+		}
+
+		c.dohashBatch(keys[:n], h[:n])
+		for i, k := range keys[:n] {
+			vals[i], ok[i] = c.searchHashed(k, &h[i])
+		}
+
+		keys, vals, ok = keys[n:], vals[n:], ok[n:]
+	}
+}
+
+// SearchBitmap is SearchBatch for membership only: bit i of the bitmap (bitmap[i/64]>>(i%64)&1) is set if keys[i] is present,
+// and cleared otherwise. bitmap must have at least (len(keys)+63)/64 words. It returns the number of keys present.
+// This takes 64 times less memory than a []bool for the results.
+func (c *Cuckoo) SearchBitmap(keys []Key, bitmap []uint64) (found int) {
+	var h [searchBatch][nhash]hash
+	for base := 0; base < len(keys); base += searchBatch {
+		batch := keys[base:]
+		if len(batch) > searchBatch {
+			batch = batch[:searchBatch]
+		}
+
+		// searchBatch is 64, so a batch fills exactly one word.
+		c.dohashBatch(batch, h[:len(batch)])
+		word := uint64(0)
+		for i, k := range batch {
+			if _, ok := c.searchHashed(k, &h[i]); ok {
+				word |= 1 << uint(i)
+				found++
+			}
+		}
+		bitmap[base/searchBatch] = word
+	}
+	return found
+}
+
+// searchHashed is Search with the candidate buckets of k already computed.
+func (c *Cuckoo) searchHashed(k Key, h *[nhash]hash) (v Value, ok bool) {
+	if k == 0 {
+		return c.zeroValue, c.zeroIsSet
+	}
+
+	for _, hval := range h {
+		b := &c.buckets[int(hval)]
+		for i, key := range &b.keys {
+			if k == key {
+				return b.vals[i], true
+			}
+		}
+	}
+
+	for i, key := range c.stash.keys {
+		if key == k {
+			return c.stash.vals[i], true
+		}
 	}
 
 	return
@@ -145,6 +374,10 @@ func (c *Cuckoo) shuffle(h *[nhash]hash, r int64) {
 // Search tries to retrieve the value associated with the given key.
 // If no such item is found, ok is set to false.
 func (c *Cuckoo) Search(k Key) (v Value, ok bool) {
+	if instrument {
+		defer c.latency(&c.stats().search, time.Now())
+	}
+
 	if k == 0 {
 		if c.zeroIsSet == false {
 			return
@@ -175,11 +408,72 @@ func (c *Cuckoo) Search(k Key) (v Value, ok bool) {
 	return
 }
 
+// lookup returns a pointer to the value associated with the given key, or nil if there is no such item.
+// The pointer is valid until the next modification of the hash map.
+func (c *Cuckoo) lookup(k Key) *Value {
+	if k == 0 {
+		if c.zeroIsSet == false {
+			return nil
+		}
+
+		return &c.zeroValue
+	}
+
+	var h [nhash]hash
+	c.dohash(k, &h)
+	for _, hval := range &h {
+		b := &c.buckets[int(hval)]
+		for i, key := range &b.keys {
+			if k == key {
+				return &b.vals[i]
+			}
+		}
+	}
+
+	for i, key := range c.stash.keys {
+		if key == k {
+			return &c.stash.vals[i]
+		}
+	}
+
+	return nil
+}
+
+// Upsert sets the value associated with the given key to f(old, exists),
+// where old is the current value (if exists is true), and updates it in place.
+// f must not modify the hash map.
+func (c *Cuckoo) Upsert(k Key, f func(old Value, exists bool) Value) {
+	if p := c.lookup(k); p != nil {
+		*p = f(*p, true)
+		c.version++
+		return
+	}
+
+	c.Insert(k, f(zero, false))
+}
+
+// GetOrInsert returns the value associated with the given key if there is one (and sets loaded to true).
+// Otherwise, it inserts the given key/value item and returns v.
+func (c *Cuckoo) GetOrInsert(k Key, v Value) (actual Value, loaded bool) {
+	if p := c.lookup(k); p != nil {
+		return *p, true
+	}
+
+	c.Insert(k, v)
+	return v, false
+}
+
 // Delete removes the item corresponding to the given key (if exists).
 func (c *Cuckoo) Delete(k Key) {
+	if instrument {
+		defer c.latency(&c.stats().delete, time.Now())
+	}
+	c.tick()
+
 	if c.tryDelete(k) == false {
 		return
 	}
+	c.version++
 
 	if 1<<uint(c.logsize+bshift-shrinkFactor) > c.nentries {
 		// TODO(utkan): depending on the current load factorm starting from shrinkFactor-1 may be better.
@@ -193,6 +487,10 @@ func (c *Cuckoo) Delete(k Key) {
 
 func (c *Cuckoo) tryDelete(k Key) bool {
 	if k == 0 {
+		if c.zeroIsSet == false {
+			return false
+		}
+
 		c.zeroIsSet = false
 		c.zeroValue = zero
 		c.nentries--
@@ -228,10 +526,19 @@ func (c *Cuckoo) tryDelete(k Key) bool {
 // Insert adds given key/value item into the hash map.
 // If an item with key k already exists, it will be replaced.
 func (c *Cuckoo) Insert(k Key, v Value) {
+	if instrument {
+		defer c.latency(&c.stats().insert, time.Now())
+	}
+	c.tick()
+	c.version++
+
 	if k == 0 {
+		if c.zeroIsSet == false {
+			c.nentries++
+		}
+
 		c.zeroIsSet = true
 		c.zeroValue = v
-		c.nentries++
 		return
 	}
 
@@ -245,7 +552,22 @@ func (c *Cuckoo) Insert(k Key, v Value) {
 			i0 = 0
 		}
 
+		if c.LoadFactor() < floodThreshold {
+			// random keys practically never fail this early; the keys are probably chosen to collide.
+			// the rehash below picks new seeds, which defeats keys crafted against the old ones.
+			c.nflood++
+			if c.onFlood != nil {
+				c.onFlood(c.LoadFactor())
+			}
+		}
+
 		for i := i0; ; i++ {
+			// like Rehash, a rebuild at the current size is tried even if the table is beyond its size limit.
+			if i > 0 && !c.mayGrow(i) {
+				c.reject()
+				return
+			}
+
 			if ok := c.tryGrow(i); ok {
 				break
 			}
@@ -253,6 +575,203 @@ func (c *Cuckoo) Insert(k Key, v Value) {
 	}
 }
 
+// InsertUnique adds given key/value item into the hash map only if there is no item with key k yet.
+// It reports whether the item was added; an existing item is left untouched.
+// At the size limit (see SetMaxLogSize), the item counts as added if it is in the hash map afterwards, even if another item was evicted for it.
+func (c *Cuckoo) InsertUnique(k Key, v Value) (added bool) {
+	if _, ok := c.Search(k); ok {
+		return false
+	}
+
+	c.Insert(k, v)
+	_, added = c.Search(k)
+	return added
+}
+
+// reject gets rid of the leftover item of a failed Insert according to the admission policy.
+func (c *Cuckoo) reject() {
+	if c.admission == RejectNew {
+		// walk back, putting every item where it was before the Insert call.
+		// what remains in hand is the new item.
+		for s := c.elen - 1; s >= 0; s-- {
+			b := &c.buckets[c.epath[s]>>bshift]
+			i := c.epath[s] & bmask
+			b.keys[i], c.ekey = c.ekey, b.keys[i]
+			b.vals[i], c.eval = c.eval, b.vals[i]
+		}
+	}
+
+	k, v := c.ekey, c.eval
+	c.eitem = false
+	c.ekey = 0
+	c.eval = zero
+	c.elen = 0
+	c.nrejected++
+
+	if c.onEvict != nil {
+		c.onEvict(k, v)
+	}
+}
+
+// SetEvictHandler registers f to be called with every item dropped due to the size limit (see SetMaxLogSize),
+// so that the caller can keep it elsewhere instead of losing it. f is called from within Insert after the table
+// is back in a consistent state; it must not modify the hash map. Passing nil removes the handler.
+func (c *Cuckoo) SetEvictHandler(f func(Key, Value)) {
+	c.onEvict = f
+}
+
+// SetFloodHandler registers f to be called when an insertion fails to place an item although the load factor is below floodThreshold.
+// With random keys this is extremely unlikely, so it indicates hash flooding: keys crafted to collide under the current seeds.
+// The table responds by rehashing with new seeds in any case; f lets the application log or act on the attack.
+// f is called with the load factor at the time; it must not modify the hash map. Passing nil removes the handler.
+func (c *Cuckoo) SetFloodHandler(f func(load float64)) {
+	c.onFlood = f
+}
+
+// SetMaxLogSize limits the size of the hash table to 2^logsize key/value cells (logsize is interpreted as in NewCuckoo).
+// Once the limit is reached, an Insert that would otherwise grow the table drops an item instead, as determined by the admission policy.
+// A logsize of 0 removes the limit. The limit is checked only when the table needs to grow; a table that is already larger is not shrunk.
+func (c *Cuckoo) SetMaxLogSize(logsize int) {
+	if logsize == 0 {
+		c.maxLogsize = 0
+		return
+	}
+
+	logsize -= bshift
+	if logsize <= 0 {
+		logsize = 1
+	}
+	c.maxLogsize = logsize
+}
+
+// SetGrowHandler sets a function which is called before every grow of the table from oldCap to newCap cells,
+// whether by Insert, Rehash, SetHash or ReadFrom. Returning false vetoes the grow: the table is then treated as if it
+// had reached its size limit (see SetMaxLogSize); Insert handles the item according to the admission policy.
+// Rebuilding the table at its current size and shrinking it do not consult f. A nil f (the default) allows every grow.
+// The table always doubles (or grows by a larger power of 2), since bucket indices are taken from the bits of the hash.
+func (c *Cuckoo) SetGrowHandler(f func(oldCap, newCap int) bool) {
+	c.onGrow = f
+}
+
+// SetAdmission sets the policy used when the table has reached the size limit set by SetMaxLogSize.
+func (c *Cuckoo) SetAdmission(a Admission) {
+	c.admission = a
+}
+
+// Rejected returns the number of items dropped so far because the table had reached its size limit.
+func (c *Cuckoo) Rejected() int {
+	return c.nrejected
+}
+
+func (c *Cuckoo) canGrow(δ int) bool {
+	return c.maxLogsize == 0 || c.logsize+δ <= c.maxLogsize
+}
+
+// mayGrow reports whether the table may grow by a factor of 2^δ: the size limit allows it, and the grow handler doesn't veto it.
+// Every grow goes through here, so that the handler sees each of them.
+func (c *Cuckoo) mayGrow(δ int) bool {
+	if !c.canGrow(δ) {
+		return false
+	}
+	return δ <= 0 || c.onGrow == nil || c.onGrow(c.Cap(), c.Cap()<<uint(δ))
+}
+
+// InsertResult describes what it took to insert an item, see InsertWithResult.
+type InsertResult struct {
+	Updated  bool // an item with the same key was already present, only its value was replaced.
+	Bucket   int  // index of the bucket the item ended up in; -1 if it is in the stash, is the item with key 0, or was rejected.
+	Stash    bool // the item ended up in the stash.
+	Kicks    int  // number of items evicted by random walks, including those during rebuilds of the table.
+	Grows    int  // number of times the table was rebuilt, whether to grow or just to rehash.
+	Rejected bool // the item was dropped due to the size limit, see SetMaxLogSize.
+	Evicted  bool // the item was stored, but another item was dropped in its place due to the size limit (see EvictRandom).
+}
+
+// InsertWithResult is like Insert, but also reports how the insertion went.
+// This is meant for finding the cause of slow insertions in a workload without instrumenting every call.
+func (c *Cuckoo) InsertWithResult(k Key, v Value) (r InsertResult) {
+	_, r.Updated = c.Search(k)
+	kicks, grows, rejected := c.nkicks, c.ngrow+c.nrehash, c.nrejected
+
+	c.Insert(k, v)
+
+	r.Kicks = c.nkicks - kicks
+	r.Grows = c.ngrow + c.nrehash - grows
+	r.Bucket, r.Stash = c.locate(k)
+	present := r.Bucket >= 0 || r.Stash || (k == 0 && c.zeroIsSet)
+	r.Rejected = !present
+	r.Evicted = present && c.nrejected != rejected
+
+	return
+}
+
+// Probe describes one location examined by a lookup, see ProbeSequence.
+type Probe struct {
+	Bucket int // index of the bucket, or -1 for the stash.
+	Used   int // number of occupied cells in it.
+}
+
+// ProbeSequence returns the locations a lookup of k examines, in order: its candidate buckets, then the stash.
+// Since these depend on the current size and seeds of the table, the result is valid until the next modification.
+// This lets external systems prefetch the memory a query is about to touch. Key 0 is kept aside and has no probes.
+func (c *Cuckoo) ProbeSequence(k Key) []Probe {
+	return c.ProbeSequenceInto(k, make([]Probe, 0, nhash+1))
+}
+
+// ProbeSequenceInto is ProbeSequence storing the result in buf (overwriting its contents), which avoids allocation if buf
+// has room for NumHash+1 probes. It returns the resulting slice.
+func (c *Cuckoo) ProbeSequenceInto(k Key, buf []Probe) []Probe {
+	probes := buf[:0]
+	if k == 0 {
+		return probes
+	}
+
+	var h [nhash]hash
+	c.dohash(k, &h)
+
+	for _, hval := range &h {
+		p := Probe{Bucket: int(hval)}
+		for _, key := range &c.buckets[int(hval)].keys {
+			if key != 0 {
+				p.Used++
+			}
+		}
+		probes = append(probes, p)
+	}
+
+	p := Probe{Bucket: -1}
+	for _, key := range c.stash.keys {
+		if key != 0 {
+			p.Used++
+		}
+	}
+
+	return append(probes, p)
+}
+
+// locate returns the index of the bucket holding k, or -1 if k is not in a bucket.
+func (c *Cuckoo) locate(k Key) (ibucket int, stashed bool) {
+	if k != 0 {
+		var h [nhash]hash
+		c.dohash(k, &h)
+		for _, hval := range &h {
+			for _, key := range &c.buckets[int(hval)].keys {
+				if k == key {
+					return int(hval), false
+				}
+			}
+		}
+
+		for _, key := range c.stash.keys {
+			if k == key {
+				return -1, true
+			}
+		}
+	}
+
+	return -1, false
+}
+
 func (c *Cuckoo) tryInsert(k Key, v Value) (inserted bool) {
 	var h [nhash]hash
 	c.dohash(k, &h)
@@ -267,17 +786,18 @@ func (c *Cuckoo) tryInsert(k Key, v Value) (inserted bool) {
 	if freeSlot {
 		c.addAt(k, v, ibucket, index)
 		c.nentries++
+		c.recordChain(0)
 		return true
 	}
 
 	// Nope again, lets try moving the eggs around.
-	if c.tryGreedyAdd(k, v, &h) {
+	inserted = c.tryGreedyAdd(k, v, &h)
+	c.recordChain(c.elen)
+	if inserted {
 		c.nentries++
-		return true
 	}
 
-	// All failed.
-	return false
+	return
 }
 
 // If we already have an element with the the key k, we just update the value.
@@ -356,9 +876,13 @@ func (c *Cuckoo) tryGreedyAdd(k Key, v Value, h *[nhash]hash) (added bool) {
 	// Expected maximum number of steps is O(log(n)):
 	// Frieze, Alan, Páll Melsted, and Michael Mitzenmacher. "An analysis of random-walk cuckoo hashing." SIAM Journal on Computing 40.2 (2011): 291-308.
 	max := (1 + c.logsize) * randomWalkCoefficient
+	if faultWalk() {
+		max = 0
+	}
 
 	var ehash [nhash]hash
 
+	c.elen = 0
 	for step := 0; step < max; step++ {
 		r := rand.Int63() // need nhash*nhashshift + bshift + nhashshift random bits
 		c.shuffle(h, r)
@@ -370,6 +894,9 @@ func (c *Cuckoo) tryGreedyAdd(k Key, v Value, h *[nhash]hash) (added bool) {
 		b := &c.buckets[int(hval)]
 		ekey, eval := b.keys[i], b.vals[i]
 		b.keys[i], b.vals[i] = k, v
+		c.epath[step] = int(hval)<<bshift | i
+		c.elen++
+		c.nkicks++
 		// try to put the evicted item back
 		c.dohash(ekey, &ehash)
 		if c.tryAdd(ekey, eval, &ehash, true, hval) {
@@ -384,7 +911,7 @@ func (c *Cuckoo) tryGreedyAdd(k Key, v Value, h *[nhash]hash) (added bool) {
 
 	// try to insert into stash as a last resort
 	for i, key := range c.stash.keys {
-		if key == 0 {
+		if key == 0 && !faultStash() {
 			c.stash.keys[i] = k
 			c.stash.vals[i] = v
 			return true
@@ -400,7 +927,114 @@ func (c *Cuckoo) tryGreedyAdd(k Key, v Value, h *[nhash]hash) (added bool) {
 // LoadFactor returns the load factor of the hash table, which is the
 // ratio of the used cells to the allocated cells.
 func (c *Cuckoo) LoadFactor() float64 {
-	return float64(c.nentries) / float64(len(c.buckets)<<bshift)
+	return float64(c.nentries) / float64(c.Cap())
+}
+
+// Cap returns the number of allocated key/value cells, which is always a power of 2.
+// The stash and the cell for key 0 come on top of it.
+func (c *Cuckoo) Cap() int {
+	return len(c.buckets) << bshift
+}
+
+// Rehash picks new seeds for the hash functions and moves every item to its place under the new seeds.
+// All items remain accessible afterwards. In the unlikely case the items cannot be placed with the new seeds,
+// the table is grown, exactly like Insert would do; if the table cannot grow due to SetMaxLogSize or SetGrowHandler, it is left unchanged.
+// A table already at or beyond its size limit is still rehashed at its current size.
+func (c *Cuckoo) Rehash() {
+	for i := 0; i == 0 || c.mayGrow(i); i++ {
+		if ok := c.tryGrow(i); ok {
+			break
+		}
+	}
+}
+
+// SetHash replaces the hash function used to place keys (nil restores the default one), and moves every item to its place
+// under the new function; see Rehash. It returns false, leaving the hash map unchanged, if the items cannot be placed
+// with the new function because the table cannot grow due to SetMaxLogSize or SetGrowHandler. Like Rehash, it always tries the current size first.
+func (c *Cuckoo) SetHash(f HashFunc) bool {
+	old := c.hashfn
+	c.hashfn = f
+	for i := 0; i == 0 || c.mayGrow(i); i++ {
+		if ok := c.tryGrow(i); ok {
+			return true
+		}
+	}
+	c.hashfn = old
+	return false
+}
+
+// Clone returns an independent copy of c, with the same items, size, seeds, settings and metadata.
+// It copies the table with a memmove, which is much faster than inserting the items anew.
+func (c *Cuckoo) Clone() *Cuckoo {
+	cc := &Cuckoo{}
+	*cc = *c
+	cc.buckets = alloc(len(c.buckets))
+	copy(cc.buckets, c.buckets)
+	cc.labels = nil
+	for k, v := range c.labels {
+		cc.SetLabel(k, v)
+	}
+	if c.lat != nil {
+		lat := *c.lat
+		cc.lat = &lat
+	}
+	return cc
+}
+
+// Reset removes all items from the hash map, keeping its size, settings (size limit, handlers, alarms) and metadata.
+// The buckets are cleared in place, so no memory is allocated.
+func (c *Cuckoo) Reset() {
+	for i := range c.buckets {
+		c.buckets[i] = bucket{}
+	}
+	c.stash = stash{}
+	c.zeroValue, c.zeroIsSet = zero, false
+	c.eitem, c.ekey, c.eval = false, 0, zero
+	c.nentries = 0
+	c.version++
+	c.reseed()
+}
+
+// Clear removes the items whose keys satisfy pred, in place, and returns the number of items removed.
+// Like Reset, it never allocates nor shrinks the table.
+func (c *Cuckoo) Clear(pred func(Key) bool) int {
+	return c.DeleteWhere(func(k Key, v Value) bool { return pred(k) })
+}
+
+// DeleteWhere removes the items which satisfy pred, in place, and returns the number of items removed.
+// Like Reset, it never allocates nor shrinks the table.
+func (c *Cuckoo) DeleteWhere(pred func(Key, Value) bool) int {
+	n := c.nentries
+
+	if c.zeroIsSet && pred(0, c.zeroValue) {
+		c.zeroIsSet = false
+		c.zeroValue = zero
+		c.nentries--
+	}
+
+	for bi := range c.buckets {
+		b := &c.buckets[bi]
+		for i, key := range &b.keys {
+			if key != 0 && pred(key, b.vals[i]) {
+				b.keys[i] = 0
+				b.vals[i] = zero
+				c.nentries--
+			}
+		}
+	}
+
+	for i, key := range c.stash.keys {
+		if key != 0 && pred(key, c.stash.vals[i]) {
+			c.stash.keys[i] = 0
+			c.stash.vals[i] = zero
+			c.nentries--
+		}
+	}
+
+	if c.nentries != n {
+		c.version++
+	}
+	return n - c.nentries
 }
 
 // Tries to grow the hash table by a factor of 2^δ.
@@ -436,6 +1070,7 @@ func (c *Cuckoo) tryGrow(δ int) (ok bool) {
 	defer func() {
 		if ok {
 			*c = *cnew
+			c.checkSkew()
 		}
 
 		cnew = nil
@@ -472,6 +1107,8 @@ func (c *Cuckoo) tryGrow(δ int) (ok bool) {
 			return
 		}
 		cnew.eitem = false
+		cnew.ekey = 0
+		cnew.eval = zero // don't keep the value alive if Value holds references.
 	}
 
 	ok = true
@@ -499,3 +1136,54 @@ func (c *Cuckoo) ForRange(f func(Key, Value)) {
 		}
 	}
 }
+
+// Intersect returns a new hash map holding the items of c whose keys are also present in other.
+// Values are taken from c.
+func (c *Cuckoo) Intersect(other *Cuckoo) *Cuckoo {
+	n := c.Len()
+	if other.Len() < n {
+		n = other.Len()
+	}
+
+	r := NewCuckoo(LogSizeFor(n))
+	c.ForRange(func(k Key, v Value) {
+		if _, ok := other.Search(k); ok {
+			r.Insert(k, v)
+		}
+	})
+
+	return r
+}
+
+// Sample returns up to n keys chosen uniformly at random from the hash map.
+func (c *Cuckoo) Sample(n int) []Key {
+	if n > c.nentries {
+		n = c.nentries
+	}
+	if n <= 0 {
+		return nil
+	}
+	return c.SampleInto(n, make([]Key, 0, n))
+}
+
+// SampleInto is Sample storing the result in buf (overwriting its contents), which avoids allocation if buf has room for n keys.
+// It returns the resulting slice.
+func (c *Cuckoo) SampleInto(n int, buf []Key) []Key {
+	if n > c.nentries {
+		n = c.nentries
+	}
+
+	// reservoir sampling
+	keys := buf[:0]
+	seen := 0
+	c.ForRange(func(k Key, v Value) {
+		if seen < n {
+			keys = append(keys, k)
+		} else if i := rand.Intn(seen + 1); i < n {
+			keys[i] = k
+		}
+		seen++
+	})
+
+	return keys
+}
@@ -16,11 +16,22 @@
 package cuckoo
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
 	"math"
 	"math/rand"
 	"reflect"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 var n = int(2e6) // close enough to a power of 2, to test whether the LoadFactor is close to 1 or not.
@@ -85,51 +96,1706 @@ func TestZero(t *testing.T) {
 			t.Error("search failed")
 		}
 	}
+
+	if c.Len() != 1 {
+		t.Error("got: ", c.Len(), " expected: ", 1)
+	}
+
+	for i := 0; i < 10; i++ {
+		c.Delete(0)
+		if _, ok := c.Search(0); ok {
+			t.Error("search succeeded")
+		}
+	}
+
+	if c.Len() != 0 {
+		t.Error("got: ", c.Len(), " expected: ", 0)
+	}
+}
+
+func TestSimple(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for k, v := range gmap {
+		c.Insert(k, v)
+	}
+
+	for k, v := range gmap {
+		cv, ok := c.Search(k)
+		if !ok {
+			t.Error("not ok:", k, v, cv)
+			return
+		}
+		if reflect.DeepEqual(cv, v) == false {
+			t.Error("got: ", cv, " expected: ", v)
+			return
+		}
+	}
+
+	if c.Len() != len(gmap) {
+		t.Error("got: ", c.Len(), " expected: ", len(gmap))
+		return
+	}
+
+	ndeleted := 0
+	maxdelete := len(gmap) * 95 / 100
+	for k := range gmap {
+		if ndeleted >= maxdelete {
+			break
+		}
+
+		c.Delete(k)
+		if v, ok := c.Search(k); ok == true {
+			t.Error("got: ", v)
+			return
+		}
+
+		ndeleted++
+
+		if c.Len() != len(gmap)-ndeleted {
+			t.Error("got: ", c.Len(), " expected: ", len(gmap)-ndeleted)
+			return
+		}
+	}
+}
+
+func TestInsertUnique(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 0; i < 1000; i++ {
+		if !c.InsertUnique(Key(i), Value(i)) {
+			t.Error("not added: ", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if c.InsertUnique(Key(i), Value(i+1)) {
+			t.Error("added twice: ", i)
+		}
+		if v, _ := c.Search(Key(i)); v != Value(i) {
+			t.Error("got: ", v, " expected: ", i)
+		}
+	}
+	if c.Len() != 1000 {
+		t.Error("got: ", c.Len(), " expected: ", 1000)
+	}
+
+	for _, a := range []Admission{RejectNew, EvictRandom} {
+		c := NewCuckoo(DefaultLogSize)
+		c.SetMaxLogSize(DefaultLogSize)
+		c.SetAdmission(a)
+		nadded := 0
+		for i := 1; i <= 2<<DefaultLogSize; i++ {
+			added := c.InsertUnique(Key(i), Value(i))
+			if _, ok := c.Search(Key(i)); ok != added {
+				t.Fatal("got: ", added, " expected: ", ok, " under ", a)
+			}
+			if added {
+				nadded++
+			}
+		}
+		// under EvictRandom, most dropped items are old ones evicted for a new item which counts as added.
+		if ndropped := 2<<DefaultLogSize - nadded; c.Rejected() == 0 || (ndropped == c.Rejected()) != (a == RejectNew) {
+			t.Error("got: ", ndropped, " not added, ", c.Rejected(), " dropped under ", a)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	c := NewCuckoo(0)
+	count := func(old Value, exists bool) Value {
+		if !exists {
+			return 1
+		}
+		return old + 1
+	}
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			c.Upsert(Key(i), count)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if v, _ := c.Search(Key(i)); v != 3 {
+			t.Error("got: ", v, " expected: ", 3)
+			return
+		}
+	}
+
+	if v, loaded := c.GetOrInsert(1, 10); !loaded || v != 3 {
+		t.Error("got: ", v, loaded, " expected: ", 3, true)
+	}
+	if v, loaded := c.GetOrInsert(1000, 10); loaded || v != 10 {
+		t.Error("got: ", v, loaded, " expected: ", 10, false)
+	}
+	if c.Len() != 1001 {
+		t.Error("got: ", c.Len(), " expected: ", 1001)
+	}
+}
+
+func TestCap(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	if c.Cap() != 1<<DefaultLogSize {
+		t.Error("got: ", c.Cap(), " expected: ", 1<<DefaultLogSize)
+	}
+
+	for i := 0; i < 2<<DefaultLogSize; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	if c.Cap() < c.Len()-stashSize-1 || c.Cap()&(c.Cap()-1) != 0 {
+		t.Error("unexpected capacity: ", c.Cap())
+	}
+}
+
+func TestRehash(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for k, v := range gmap {
+		c.Insert(k, v)
+	}
+
+	seed := c.seed
+	c.Rehash()
+	if seed == c.seed {
+		t.Error("seeds did not change")
+	}
+
+	for k, v := range gmap {
+		cv, ok := c.Search(k)
+		if !ok {
+			t.Error("not ok:", k, v, cv)
+			return
+		}
+		if reflect.DeepEqual(cv, v) == false {
+			t.Error("got: ", cv, " expected: ", v)
+			return
+		}
+	}
+
+	if c.Len() != len(gmap) {
+		t.Error("got: ", c.Len(), " expected: ", len(gmap))
+	}
+
+	// a limit below the current size must not keep Rehash from rebuilding the table at its size.
+	c.SetMaxLogSize(DefaultLogSize)
+	seed = c.seed
+	c.Rehash()
+	if seed == c.seed {
+		t.Error("seeds did not change beyond the size limit")
+	}
+}
+
+func TestSetHash(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for k, v := range gmap {
+		c.Insert(k, v)
+	}
+
+	mix := func(k Key, seed uint32) uint32 {
+		h := (uint32(k) ^ seed) * 0x9e3779b1
+		h ^= h >> 15
+		h *= 0x85ebca77
+		return h ^ h>>13
+	}
+	if !c.SetHash(mix) {
+		t.Fatal("SetHash failed")
+	}
+
+	keys := make([]Key, 0, len(gmap))
+	for k, v := range gmap {
+		if cv, ok := c.Search(k); !ok || reflect.DeepEqual(cv, v) == false {
+			t.Fatal("got: ", cv, ok, " expected: ", v)
+		}
+		keys = append(keys, k)
+	}
+	vals, oks := make([]Value, len(keys)), make([]bool, len(keys))
+	c.SearchBatch(keys, vals, oks)
+	for i, ok := range oks {
+		if !ok {
+			t.Fatal("SearchBatch missed key: ", keys[i])
+		}
+	}
+
+	c.SetMaxLogSize(c.logsize + bshift)
+	if c.SetHash(func(Key, uint32) uint32 { return 0 }) {
+		t.Error("degenerate hash accepted")
+	}
+	for k := range gmap {
+		if _, ok := c.Search(k); !ok {
+			t.Fatal("lost key after failed SetHash: ", k)
+		}
+	}
+
+	// a limit below the current size must not keep SetHash from rebuilding the table at its size.
+	c.SetMaxLogSize(DefaultLogSize)
+	if !c.SetHash(nil) || c.hashfn != nil {
+		t.Fatal("SetHash failed beyond the size limit")
+	}
+	for k := range gmap {
+		if _, ok := c.Search(k); !ok {
+			t.Fatal("lost key after SetHash: ", k)
+		}
+	}
+}
+
+func TestInsertBeyondLimit(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize + 2)
+	c.SetMaxLogSize(DefaultLogSize)
+	// under these seeds, every key goes to bucket 0; an insertion failing there must rehash with new seeds rather than reject.
+	c.SetHash(func(k Key, seed uint32) uint32 {
+		if seed == 1 {
+			return 0
+		}
+		h := (uint32(k) ^ seed) * 0x9e3779b1
+		return h ^ h>>15
+	})
+	for i := range c.seed {
+		c.seed[i] = 1
+	}
+
+	for i := 1; i <= 100; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	if c.Rejected() != 0 || c.Len() != 100 || c.nflood == 0 {
+		t.Error("got: ", c.Rejected(), c.Len(), c.nflood, " expected: ", 0, 100, "> 0")
+	}
+	for i := 1; i <= 100; i++ {
+		if v, ok := c.Search(Key(i)); !ok || v != Value(i) {
+			t.Fatal("got: ", v, ok, " expected: ", i)
+		}
+	}
+}
+
+func TestGrowHandler(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	limit := 2 << DefaultLogSize
+	ncalls := 0
+	c.SetGrowHandler(func(oldCap, newCap int) bool {
+		ncalls++
+		if oldCap != c.Cap() || newCap <= oldCap {
+			t.Error("unexpected grow: ", oldCap, newCap)
+		}
+		return newCap <= limit
+	})
+
+	for i := 1; i <= 2*limit; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	if c.Cap() != limit || c.Rejected() == 0 || ncalls < 2 {
+		t.Error("got: ", c.Cap(), c.Rejected(), ncalls, " expected: ", limit, "> 0", ">= 2")
+	}
+
+	// the other ways a table grows ask the handler as well.
+	ncalls = 0
+	if c.SetHash(func(Key, uint32) uint32 { return 0 }) || ncalls == 0 || c.Cap() != limit {
+		t.Error("SetHash grew without asking: ", ncalls, c.Cap())
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	d := NewCuckoo(DefaultLogSize)
+	ncalls = 0
+	d.SetGrowHandler(func(oldCap, newCap int) bool {
+		ncalls++
+		return false
+	})
+	if _, err := d.ReadFrom(&buf); err != ErrDropped {
+		t.Error("got: ", err, " expected: ", ErrDropped)
+	}
+	if d.Cap() != 1<<DefaultLogSize || d.Rejected() == 0 || ncalls == 0 {
+		t.Error("ReadFrom grew without asking: ", d.Cap(), d.Rejected(), ncalls)
+	}
+}
+
+func TestMaxLogSize(t *testing.T) {
+	for _, a := range []Admission{RejectNew, EvictRandom} {
+		c := NewCuckoo(DefaultLogSize)
+		c.SetMaxLogSize(DefaultLogSize)
+		c.SetAdmission(a)
+
+		evicted := make(map[Key]Value)
+		c.SetEvictHandler(func(k Key, v Value) {
+			evicted[k] = v
+		})
+
+		m := make(map[Key]Value)
+		ninserted := 0
+		for k, v := range gmap {
+			if ninserted == 2<<DefaultLogSize {
+				break
+			}
+
+			rejected := c.Rejected()
+			c.Insert(k, v)
+			m[k] = v
+			ninserted++
+
+			if a == RejectNew && c.Rejected() != rejected {
+				if _, ok := c.Search(k); ok {
+					t.Error("rejected key found:", k)
+					return
+				}
+				delete(m, k)
+			}
+		}
+
+		if c.Rejected() == 0 {
+			t.Error("nothing was rejected")
+		}
+		if c.logsize > DefaultLogSize-bshift {
+			t.Error("table grew beyond limit")
+		}
+		if c.Len()+c.Rejected() != ninserted {
+			t.Error("got: ", c.Len()+c.Rejected(), " expected: ", ninserted)
+		}
+		if len(evicted) != c.Rejected() {
+			t.Error("got: ", len(evicted), " expected: ", c.Rejected())
+		}
+		for k := range evicted {
+			if _, ok := c.Search(k); ok {
+				t.Error("evicted key found:", k)
+			}
+		}
+
+		if a != RejectNew {
+			continue
+		}
+		for k, v := range m {
+			if cv, ok := c.Search(k); !ok || reflect.DeepEqual(cv, v) == false {
+				t.Error("got: ", cv, ok, " expected: ", v)
+				return
+			}
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	a, b := NewCuckoo(DefaultLogSize), NewCuckoo(DefaultLogSize)
+	for i := 0; i < 1000; i++ {
+		a.Insert(Key(i), Value(i))
+		b.Insert(Key(i+500), Value(0))
+	}
+
+	c := a.Intersect(b)
+	if c.Len() != 500 || c.ngrow != 0 {
+		t.Error("got: ", c.Len(), c.ngrow, " expected: ", 500, 0)
+	}
+	c.ForRange(func(k Key, v Value) {
+		if k < 500 || k >= 1000 || v != Value(k) {
+			t.Error("unexpected item: ", k, v)
+		}
+	})
+}
+
+func TestSample(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 0; i < 100; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+
+	keys := c.Sample(10)
+	if len(keys) != 10 {
+		t.Error("got: ", len(keys), " expected: ", 10)
+	}
+	seen := make(map[Key]bool)
+	for _, k := range keys {
+		if _, ok := c.Search(k); !ok || seen[k] {
+			t.Error("unexpected key: ", k)
+		}
+		seen[k] = true
+	}
+
+	if keys := c.Sample(1000); len(keys) != c.Len() {
+		t.Error("got: ", len(keys), " expected: ", c.Len())
+	}
+
+	buf := make([]Key, 10)
+	if n := testing.AllocsPerRun(10, func() { keys = c.SampleInto(10, buf) }); n != 0 || len(keys) != 10 {
+		t.Error("got: ", n, len(keys), " expected: ", 0, 10)
+	}
+}
+
+func TestInsertWithResult(t *testing.T) {
+	c := NewCuckoo(0)
+
+	kicked, grown := false, false
+	for i := 1; i <= 1000; i++ {
+		r := c.InsertWithResult(Key(i), Value(i))
+		if r.Updated || r.Rejected {
+			t.Error("unexpected result: ", r)
+		}
+		if (r.Bucket < 0) != r.Stash {
+			t.Error("unexpected location: ", r)
+		}
+		kicked = kicked || r.Kicks > 0
+		grown = grown || r.Grows > 0
+	}
+	if !kicked || !grown {
+		t.Error("no kicks or grows reported")
+	}
+
+	if r := c.InsertWithResult(1, 2); !r.Updated {
+		t.Error("update not reported")
+	}
+
+	for _, a := range []Admission{RejectNew, EvictRandom} {
+		c := NewCuckoo(DefaultLogSize)
+		c.SetMaxLogSize(DefaultLogSize)
+		c.SetAdmission(a)
+		nrejected, nevicted := 0, 0
+		for i := 1; i <= 2<<DefaultLogSize; i++ {
+			r := c.InsertWithResult(Key(i), Value(i))
+			if _, ok := c.Search(Key(i)); ok == r.Rejected || (r.Rejected && r.Evicted) {
+				t.Fatal("unexpected result: ", r, " key present: ", ok)
+			}
+			if r.Rejected {
+				nrejected++
+			}
+			if r.Evicted {
+				nevicted++
+			}
+		}
+		if nrejected+nevicted != c.Rejected() || (a == RejectNew && nevicted != 0) || (a == EvictRandom && nevicted == 0) {
+			t.Error("got: ", nrejected, nevicted, " for ", c.Rejected(), " dropped items under ", a)
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	a, b := NewCuckoo(DefaultLogSize), NewCuckoo(logsize)
+	for i := 0; i < 10000; i++ {
+		a.Insert(gkeys[i], gvals[i])
+	}
+	for i := 10000 - 1; i >= 0; i-- {
+		b.Insert(gkeys[i], gvals[i])
+	}
+	for _, m := range []*Cuckoo{a, b} {
+		m.SetLabel("source", "gkeys")
+		m.SetLabel("build", "42")
+	}
+	b.Delete(gkeys[0])
+	b.Insert(gkeys[0], gvals[0])
+
+	var abuf, bbuf bytes.Buffer
+	if _, err := a.WriteContentTo(&abuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WriteContentTo(&bbuf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(abuf.Bytes(), bbuf.Bytes()) == false {
+		t.Error("content encodings of hash maps with equal items differ")
+	}
+	m := NewCuckoo(DefaultLogSize)
+	if _, err := m.ReadFrom(&abuf); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != a.Len() || m.Meta().Version != 0 || m.Meta().Labels["build"] != "42" {
+		t.Error("got: ", m.Len(), m.Meta(), " expected: ", a.Len(), " with version 0 and labels")
+	}
+
+	abuf.Reset()
+	bbuf.Reset()
+	if _, err := a.WriteTo(&abuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WriteTo(&bbuf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(abuf.Bytes(), bbuf.Bytes()) {
+		t.Error("encodings of hash maps with different metadata are equal")
+	}
+
+	c := NewCuckoo(DefaultLogSize)
+	n, err := c.ReadFrom(&abuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := c.Meta(); !m.Created.Equal(a.created) || m.Version != a.version || !reflect.DeepEqual(m.Labels, a.labels) {
+		t.Error("got: ", m, " expected: ", a.Meta())
+	}
+	if n != int64(bbuf.Len()) || abuf.Len() != 0 {
+		t.Error("got: ", n, " expected: ", bbuf.Len())
+	}
+	if c.Len() != a.Len() {
+		t.Error("got: ", c.Len(), " expected: ", a.Len())
+	}
+	a.ForRange(func(k Key, v Value) {
+		if cv, ok := c.Search(k); !ok || reflect.DeepEqual(cv, v) == false {
+			t.Error("got: ", cv, ok, " expected: ", v)
+		}
+	})
+
+	if _, err := c.ReadFrom(bytes.NewReader([]byte("not a hash map at all"))); !errors.Is(err, ErrFormat) {
+		t.Error("got: ", err, " expected: ", ErrFormat)
+	}
+
+	r, err := NewSnapshotReader(bbuf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != a.Len() || r.Meta().Version != b.version || r.Meta().Labels["build"] != "42" {
+		t.Error("got: ", r.Len(), r.Meta(), " expected: ", a.Len(), b.Meta())
+	}
+	a.ForRange(func(k Key, v Value) {
+		if rv, ok := r.Search(k); !ok || reflect.DeepEqual(rv, v) == false {
+			t.Error("got: ", rv, ok, " expected: ", v)
+		}
+	})
+	for i := 0; i < 1000; i++ {
+		k := Key(rand.Uint32())
+		_, ok := r.Search(k)
+		if _, aok := a.Search(k); ok != aok {
+			t.Error("got: ", ok, " expected: ", aok, " for key ", k)
+		}
+	}
+
+	if n, err := VerifySnapshot(bytes.NewReader(bbuf.Bytes())); err != nil || n != a.Len() {
+		t.Error("got: ", n, err, " expected: ", a.Len())
+	}
+
+	corrupt := append([]byte(nil), bbuf.Bytes()...)
+	corrupt[len(corrupt)/2] ^= 1
+	if _, err := VerifySnapshot(bytes.NewReader(corrupt)); !errors.Is(err, ErrFormat) {
+		t.Error("got: ", err, " expected: ", ErrFormat)
+	}
+	if _, err := VerifySnapshot(bytes.NewReader(bbuf.Bytes()[:len(corrupt)/2])); err != io.ErrUnexpectedEOF {
+		t.Error("got: ", err, " expected: ", io.ErrUnexpectedEOF)
+	}
+	if _, err := NewSnapshotReader(corrupt); !errors.Is(err, ErrFormat) {
+		t.Error("got: ", err, " expected: ", ErrFormat)
+	}
+	if _, err := NewSnapshotReader(corrupt[:len(corrupt)-1]); !errors.Is(err, ErrFormat) {
+		t.Error("got: ", err, " expected: ", ErrFormat)
+	}
+	d := NewCuckoo(DefaultLogSize)
+	_, err = d.ReadFrom(bytes.NewReader(corrupt))
+	if ferr, ok := err.(*FormatError); !ok || ferr.Field != "checksum" {
+		t.Error("got: ", err, " expected: checksum error")
+	}
+	if d.Len() != 0 {
+		t.Error("corrupted input was loaded")
+	}
+}
+
+func TestReadFromPresize(t *testing.T) {
+	// too many items for a table of 2^14 cells at the load factors insertions reach, but not at a load factor of 1.
+	n := 1<<14 - 500
+	c := NewCuckoo(LogSizeFor(n))
+	for i := 0; i < n; i++ {
+		c.Insert(gkeys[i], gvals[i])
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewCuckoo(DefaultLogSize)
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Stats(); s.Grows != 1 || s.Len != c.Len() || s.Cap != 1<<uint(LogSizeFor(n)) {
+		t.Error("got: ", s.Grows, s.Len, s.Cap, " expected: ", 1, c.Len(), 1<<uint(LogSizeFor(n)))
+	}
+}
+
+func TestEncodingParallel(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 0; i < 10*encSegment+123; i++ {
+		c.Insert(gkeys[i], gvals[i])
+	}
+
+	encode := func(procs int) []byte {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		var buf bytes.Buffer
+		if _, err := c.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	want := encode(1)
+	for _, procs := range []int{2, 3, 8} {
+		if got := encode(procs); !bytes.Equal(got, want) {
+			t.Error("encoding on ", procs, " goroutines differs")
+		}
+	}
+
+	d := NewCuckoo(DefaultLogSize)
+	if _, err := d.ReadFrom(bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != c.Len() {
+		t.Error("got: ", d.Len(), " expected: ", c.Len())
+	}
+
+	// a write error stops the encoding midway.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	w := &limitWriter{n: len(want) / 2}
+	if _, err := c.WriteTo(w); err != io.ErrShortWrite {
+		t.Error("got: ", err, " expected: ", io.ErrShortWrite)
+	}
+}
+
+// limitWriter fails once n bytes have been written.
+type limitWriter struct {
+	n int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, io.ErrShortWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestVerifySnapshotMemory(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for k, v := range gmap {
+		c.Insert(k, v)
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the items stream through the checksum rather than being decoded into memory.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	n, err := VerifySnapshot(bytes.NewReader(buf.Bytes()))
+	runtime.ReadMemStats(&after)
+	if err != nil || n != c.Len() {
+		t.Fatal("got: ", n, err, " expected: ", c.Len())
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > uint64(buf.Len()/16) {
+		t.Error("VerifySnapshot allocated ", alloc, " bytes for a snapshot of ", buf.Len())
+	}
+}
+
+func TestExportWhere(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for i := 0; i < 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+
+	odd := func(k Key, v Value) bool { return k%2 == 1 }
+	var buf bytes.Buffer
+	if _, err := c.ExportWhere(&buf, odd); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewCuckoo(DefaultLogSize)
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != 500 {
+		t.Error("got: ", d.Len(), " expected: ", 500)
+	}
+	d.ForRange(func(k Key, v Value) {
+		if !odd(k, v) {
+			t.Error("exported unmatched key: ", k)
+		}
+	})
+}
+
+func TestShadow(t *testing.T) {
+	var divs []Divergence
+	s := NewShadow(NewCuckoo(logsize), NewConcurrent(logsize), func(d Divergence) { divs = append(divs, d) })
+	for i := 0; i < 1000; i++ {
+		s.Insert(Key(i), Value(i))
+	}
+	for i := 0; i < 1000; i++ {
+		s.Search(Key(i))
+	}
+	if s.Len() != 1000 || s.Diverged() != 0 {
+		t.Error("unexpected divergences: ", divs)
+	}
+
+	s.New.Delete(7)
+	if v, ok := s.Search(7); !ok || v != Value(7) {
+		t.Error("got: ", v, ok, " expected: ", 7)
+	}
+	s.Len()
+	if s.Diverged() != 2 || len(divs) != 2 || divs[0].Key != 7 || divs[0].NewOK || divs[1].Op != "Len" {
+		t.Error("got: ", divs)
+	}
+}
+
+func TestPool(t *testing.T) {
+	var p Pool
+	c := p.Get(logsize)
+	for i := 1; i <= 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	c.SetMaxLogSize(logsize)
+	p.Put(c)
+
+	c = p.Get(logsize)
+	if c.Len() != 0 || c.maxLogsize != 0 {
+		t.Error("got: ", c.Len(), c.maxLogsize, " expected: ", 0, 0)
+	}
+	c.ForRange(func(k Key, v Value) {
+		t.Error("stale item: ", k)
+	})
+	c.Insert(1, 1)
+	if v, ok := c.Search(1); !ok || v != Value(1) {
+		t.Error("got: ", v, ok, " expected: ", 1)
+	}
+}
+
+func TestClear(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for i := 0; i < 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+
+	if n := c.Clear(func(k Key) bool { return k < 100 }); n != 100 {
+		t.Error("got: ", n, " expected: ", 100)
+	}
+	if c.Len() != 900 {
+		t.Error("got: ", c.Len(), " expected: ", 900)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := c.Search(Key(i)); ok != (i >= 100) {
+			t.Error("got: ", ok, " expected: ", i >= 100, " for key ", i)
+		}
+	}
+
+	if n := c.DeleteWhere(func(k Key, v Value) bool { return v >= 900 }); n != 100 {
+		t.Error("got: ", n, " expected: ", 100)
+	}
+	if c.Len() != 800 {
+		t.Error("got: ", c.Len(), " expected: ", 800)
+	}
+
+	size := c.Cap()
+	c.Reset()
+	if c.Len() != 0 || c.Cap() != size {
+		t.Error("got: ", c.Len(), c.Cap(), " expected: ", 0, size)
+	}
+	if _, ok := c.Search(500); ok {
+		t.Error("item survived Reset")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	a, b := NewCuckoo(logsize), NewConcurrent(logsize)
+	for i := 0; i < 100; i++ {
+		a.Insert(Key(i), Value(i))
+		b.Insert(Key(i+50), Value(i+50))
+	}
+
+	g := NewGroup(a, b)
+	for _, search := range []func(Key) (Value, int, bool){g.Search, g.SearchParallel} {
+		for i := 0; i < 200; i++ {
+			v, member, ok := search(Key(i))
+			want := 0
+			switch {
+			case i >= 150:
+				want = -1
+			case i >= 100:
+				want = 1
+			}
+			if member != want || ok != (want >= 0) || (ok && v != Value(i)) {
+				t.Error("got: ", v, member, ok, " expected member: ", want, " for key ", i)
+			}
+		}
+	}
+
+	if hits, misses := g.Hits(); hits[0] != 200 || hits[1] != 100 || misses != 100 {
+		t.Error("got: ", hits, misses)
+	}
+}
+
+func TestForecastFullIn(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	c.SetMaxLogSize(20)
+	if d := c.ForecastFullIn(); d >= 0 {
+		t.Error("got: ", d, " expected a negative duration before any sample")
+	}
+
+	for i := 1; i <= 100*rateSample; i++ {
+		c.Insert(Key(i), Value(i))
+		if i%rateSample == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if c.GrowthRate() <= 0 {
+		t.Error("got: ", c.GrowthRate(), " expected a positive rate")
+	}
+	if d := c.ForecastFullIn(); d <= 0 {
+		t.Error("got: ", d, " expected a positive duration")
+	}
+
+	for i := 1; i <= 100*rateSample; i++ {
+		c.Delete(Key(i))
+		if i%rateSample == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if d := c.ForecastFullIn(); d >= 0 {
+		t.Error("got: ", d, " expected a negative duration while shrinking")
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{"logSize": 12, "maxLogSize": 16, "admission": 1}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.admission != EvictRandom || c.maxLogsize != 16-bshift || c.Cap() != 1<<12 {
+		t.Error("unexpected hash map: ", c.admission, c.maxLogsize, c.Cap())
+	}
+
+	p := c.Params()
+	if p.LogSize != 12 || p.MaxLogSize != 16 || p.Admission != EvictRandom || p.NumHash != NumHash || p.Seeds == [NumHash]uint32{} {
+		t.Error("unexpected params: ", p)
+	}
+
+	for _, bad := range []Config{{LogSize: -1}, {LogSize: 16, MaxLogSize: 12}, {MaxLogSize: 8}, {Admission: 7}} {
+		if _, err := NewFromConfig(bad); err == nil {
+			t.Error("invalid config accepted: ", bad)
+		}
+	}
+}
+
+func TestTx(t *testing.T) {
+	for _, a := range []Admission{RejectNew, EvictRandom} {
+		c := NewCuckoo(DefaultLogSize)
+		c.SetMaxLogSize(DefaultLogSize)
+		c.SetAdmission(a)
+		for i := 1; i <= 1000; i++ {
+			c.Insert(Key(i), Value(i))
+		}
+
+		tx := c.Begin()
+		tx.Insert(1001, 1001)
+		tx.Delete(1)
+		if _, ok := tx.Search(1); ok {
+			t.Error("deleted key visible in transaction")
+		}
+		if _, ok := c.Search(1001); ok {
+			t.Error("uncommitted key visible in hash map")
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != ErrTxDone {
+			t.Error("got: ", err, " expected: ", ErrTxDone)
+		}
+		if _, ok := c.Search(1); ok || c.Len() != 1000 {
+			t.Error("transaction not applied")
+		}
+
+		tx = c.Begin()
+		tx.Delete(2)
+		tx.Insert(3, 0)
+		for i := 2000; i < 2000+4<<DefaultLogSize; i++ {
+			tx.Insert(Key(i), Value(i))
+		}
+		if err := tx.Commit(); err != ErrTxFull {
+			t.Error("got: ", err, " expected: ", ErrTxFull)
+		}
+		if c.Len() != 1000 || c.Rejected() != 0 {
+			t.Error("got: ", c.Len(), c.Rejected(), " expected: ", 1000, 0)
+		}
+		for i := 2; i <= 1001; i++ {
+			if v, ok := c.Search(Key(i)); !ok || v != Value(i) {
+				t.Error("got: ", v, ok, " expected: ", i)
+			}
+		}
+	}
+}
+
+func TestCountBy(t *testing.T) {
+	m := NewConcurrent(logsize)
+	for i := 0; i < 1000; i++ {
+		m.Insert(Key(i), Value(i))
+	}
+
+	counts := CountBy(m, func(k Key, v Value) string {
+		if k < 100 {
+			return "small"
+		}
+		return "large"
+	})
+	if len(counts) != 2 || counts["small"] != 100 || counts["large"] != 900 {
+		t.Error("got: ", counts)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for i := 0; i < 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+
+	dst := NewConcurrent(DefaultLogSize)
+	var calls []int
+	if err := c.MigrateTo(context.Background(), dst, 300, func(done int) { calls = append(calls, done) }); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() != 1000 || !reflect.DeepEqual(calls, []int{300, 600, 900, 1000}) {
+		t.Error("got: ", dst.Len(), calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dst = NewConcurrent(DefaultLogSize)
+	err := c.MigrateTo(ctx, dst, 100, func(done int) {
+		if done == 200 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || dst.Len() != 200 {
+		t.Error("got: ", err, dst.Len(), " expected: ", context.Canceled, 200)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	o := NewOverflow(DefaultLogSize, DefaultLogSize)
+	nspill := 0
+	o.SetSpillHandler(func(Key, Value) { nspill++ })
+
+	n := 2 << DefaultLogSize
+	for i := 1; i <= n; i++ {
+		o.Insert(Key(i), Value(i))
+	}
+	if o.Len() != n {
+		t.Error("got: ", o.Len(), " expected: ", n)
+	}
+	total, current := o.Spilled()
+	if total == 0 || total != nspill || current != total {
+		t.Error("got: ", total, current, nspill)
+	}
+
+	for i := 1; i <= n; i++ {
+		o.Insert(Key(i), Value(i+1))
+	}
+	for i := 1; i <= n; i++ {
+		if v, ok := o.Search(Key(i)); !ok || v != Value(i+1) {
+			t.Fatal("got: ", v, ok, " expected: ", i+1)
+		}
+	}
+	if o.Len() != n {
+		t.Error("got: ", o.Len(), " expected: ", n)
+	}
+
+	for i := 1; i <= n; i++ {
+		o.Delete(Key(i))
+	}
+	if o.Len() != 0 {
+		t.Error("got: ", o.Len(), " expected: ", 0)
+	}
+}
+
+func TestLogSizeFor(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 1000, 1 << 16, 1e6} {
+		logsize := LogSizeFor(n)
+		c := NewCuckoo(logsize)
+		c.SetMaxLogSize(logsize)
+		for i := 1; i <= n; i++ {
+			c.Insert(Key(i), Value(i))
+		}
+		if c.Rejected() != 0 || (logsize > bshift+1 && float64(n) <= rehashThreshold*float64(c.Cap()/2)) {
+			t.Error("bad logsize for ", n, ": ", logsize, c.Rejected())
+		}
+		if TableBytes(logsize) != c.Cap()*int(unsafe.Sizeof(bucket{}))/blen {
+			t.Error("got: ", TableBytes(logsize), " for ", c.Cap(), " cells")
+		}
+	}
+}
+
+func TestMeta(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	if c.Meta().Created.IsZero() || c.Meta().Version != 0 {
+		t.Error("unexpected meta: ", c.Meta())
+	}
+
+	c.Insert(1, 1)
+	c.Delete(2) // absent, not a modification.
+	c.Delete(1)
+	c.Upsert(3, func(Value, bool) Value { return 3 })
+	if v := c.Meta().Version; v != 3 {
+		t.Error("got: ", v, " expected: ", 3)
+	}
+
+	c.SetLabel("a", "b")
+	c.SetLabel("c", "d")
+	c.SetLabel("c", "")
+	if l := c.Meta().Labels; len(l) != 1 || l["a"] != "b" {
+		t.Error("got: ", l)
+	}
+
+	// labels up to the limit survive a round trip; longer ones could not, so they are rejected.
+	long := strings.Repeat("x", MaxLabelSize)
+	if err := c.SetLabel(long, long); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetLabel("d", long+"x"); err != ErrLabelSize {
+		t.Error("got: ", err, " expected: ", ErrLabelSize)
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	d := NewCuckoo(DefaultLogSize)
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if l := d.Meta().Labels; len(l) != 2 || l[long] != long {
+		t.Error("long label lost: ", len(l))
+	}
+
+	// a map which was never modified has version 0, but its metadata is carried over all the same.
+	e := NewCuckoo(DefaultLogSize)
+	e.created = time.Unix(1, 0)
+	buf.Reset()
+	if _, err := e.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	d = NewCuckoo(DefaultLogSize)
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if m := d.Meta(); !m.Created.Equal(e.created) || m.Version != 0 {
+		t.Error("got: ", m.Created, m.Version, " expected: ", e.created, 0)
+	}
 }
 
-func TestSimple(t *testing.T) {
+func TestClone(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for i := 0; i < 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	c.SetLabel("a", "b")
+
+	cc := c.Clone()
+	c.Delete(1)
+	c.SetLabel("a", "c")
+	if cc.Len() != 1000 || cc.Meta().Labels["a"] != "b" {
+		t.Error("clone shares state with the original")
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := cc.Search(Key(i)); !ok || v != Value(i) {
+			t.Error("got: ", v, ok, " expected: ", i)
+		}
+	}
+
+	m := NewConcurrent(logsize)
+	for i := 0; i < 1000; i++ {
+		m.Insert(Key(i), Value(i))
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; i < 2000; i++ {
+			m.Insert(Key(i), Value(i))
+		}
+	}()
+	var buf bytes.Buffer
+	before := time.Now()
+	if _, err := m.SnapshotTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	d := NewCuckoo(DefaultLogSize)
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() < 1000 || d.Len() > 2000 {
+		t.Error("got: ", d.Len(), " expected between 1000 and 2000")
+	}
+	// every item is a single insertion into one of the shards.
+	if meta := d.Meta(); meta.Version != uint64(d.Len()) || meta.Created.After(before) {
+		t.Error("got: ", meta.Version, meta.Created, " expected: ", d.Len(), " and a creation time before ", before)
+	}
+}
+
+func TestConcurrentShards(t *testing.T) {
+	for _, nshards := range []int{0, 1, 3, 64} {
+		m := NewConcurrentShards(logsize, nshards)
+		want := 1
+		for want < nshards {
+			want *= 2
+		}
+		if len(m.shards) != want {
+			t.Error("got: ", len(m.shards), " expected: ", want)
+		}
+
+		for i := 0; i < 1000; i++ {
+			m.Insert(Key(i), Value(i))
+		}
+		for i := 0; i < 1000; i++ {
+			if v, ok := m.Search(Key(i)); !ok || v != Value(i) {
+				t.Fatal("got: ", v, ok, " expected: ", i)
+			}
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	c := NewCuckoo(logsize)
+	for i := 0; i < 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+
+	pr, pw := io.Pipe()
+	standby := NewConcurrent(logsize)
+	done := make(chan error)
+	go func() { done <- Follow(pr, standby) }()
+
+	p, err := NewPrimary(c, pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1000; i < 2000; i++ {
+		p.Insert(Key(i), Value(i))
+	}
+	for i := 0; i < 500; i++ {
+		p.Delete(Key(i))
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if standby.Len() != c.Len() {
+		t.Error("got: ", standby.Len(), " expected: ", c.Len())
+	}
+	c.ForRange(func(k Key, v Value) {
+		if sv, ok := standby.Search(k); !ok || sv != v {
+			t.Error("got: ", sv, ok, " expected: ", v)
+		}
+	})
+
+	// frames with an unknown op are skipped; a truncated frame is an error.
+	var buf bytes.Buffer
+	p, err = NewPrimary(NewCuckoo(DefaultLogSize), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Insert(1, 1)
+	p.Flush()
+	buf.Write([]byte{3, 0, 0, 0, 'X', 1, 2})
+	p.Insert(2, 2)
+	p.Flush()
+	c = NewCuckoo(DefaultLogSize)
+	if err := Follow(bytes.NewReader(buf.Bytes()), c); err != nil || c.Len() != 2 {
+		t.Error("got: ", err, c.Len(), " expected: ", nil, 2)
+	}
+	if err := Follow(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), NewCuckoo(DefaultLogSize)); err != io.ErrUnexpectedEOF {
+		t.Error("got: ", err, " expected: ", io.ErrUnexpectedEOF)
+	}
+
+	// items dropped by a primary at its size limit are dropped by the standby too.
+	for _, a := range []Admission{RejectNew, EvictRandom} {
+		c := NewCuckoo(DefaultLogSize)
+		c.SetMaxLogSize(DefaultLogSize)
+		c.SetAdmission(a)
+		nevicted := 0
+		c.SetEvictHandler(func(Key, Value) { nevicted++ })
+
+		var buf bytes.Buffer
+		p, err := NewPrimary(c, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < 5000; i++ {
+			p.Insert(Key(i), Value(i))
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		standby := NewCuckoo(DefaultLogSize)
+		if err := Follow(&buf, standby); err != nil {
+			t.Fatal(err)
+		}
+		if c.Rejected() == 0 || nevicted != c.Rejected() || standby.Len() != c.Len() {
+			t.Error("got: ", standby.Len(), c.Rejected(), nevicted, " expected: ", c.Len(), "> 0", c.Rejected())
+		}
+		c.ForRange(func(k Key, v Value) {
+			if sv, ok := standby.Search(k); !ok || sv != v {
+				t.Error("got: ", sv, ok, " expected: ", v)
+			}
+		})
+	}
+}
+
+func TestSearchBitmap(t *testing.T) {
 	c := NewCuckoo(DefaultLogSize)
-	for k, v := range gmap {
-		c.Insert(k, v)
+	for i := 0; i < 1000; i += 3 {
+		c.Insert(Key(i), Value(i))
 	}
 
-	for k, v := range gmap {
-		cv, ok := c.Search(k)
-		if !ok {
-			t.Error("not ok:", k, v, cv)
-			return
+	keys := make([]Key, 1000)
+	for i := range keys {
+		keys[i] = Key(i)
+	}
+	bitmap := make([]uint64, (len(keys)+63)/64)
+	for i := range bitmap {
+		bitmap[i] = ^uint64(0)
+	}
+	if found := c.SearchBitmap(keys, bitmap); found != c.Len() {
+		t.Error("got: ", found, " expected: ", c.Len())
+	}
+	for i := range keys {
+		if got := bitmap[i/64]>>uint(i%64)&1 == 1; got != (i%3 == 0) {
+			t.Error("got: ", got, " for key ", i)
 		}
-		if reflect.DeepEqual(cv, v) == false {
-			t.Error("got: ", cv, " expected: ", v)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet(100)
+	s.Add(1).Add(2)
+	if !s.Test(1) || s.Test(3) {
+		t.Error("unexpected membership")
+	}
+	if s.TestAndAdd(3) || !s.TestAndAdd(3) {
+		t.Error("unexpected TestAndAdd")
+	}
+	if s.Remove(1).Test(1) || s.Len() != 2 {
+		t.Error("got: ", s.Len(), " expected: ", 2)
+	}
+	if s.ClearAll().Len() != 0 {
+		t.Error("set not cleared")
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	keys := append([]Key{0, 5, 5}, gkeys[:100000]...)
+	vals := append([]Value{1, 2, 3}, gvals[:100000]...)
+	c := BulkLoad(keys, vals)
+
+	m := make(map[Key]Value)
+	for i, k := range keys {
+		m[k] = vals[i]
+	}
+	if c.Len() != len(m) {
+		t.Error("got: ", c.Len(), " expected: ", len(m))
+	}
+	for k, v := range m {
+		if cv, ok := c.Search(k); !ok || reflect.DeepEqual(cv, v) == false {
+			t.Fatal("got: ", cv, ok, " expected: ", v, " for key ", k)
+		}
+	}
+
+	// the metadata must survive a round trip, which needs a non-zero version.
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	d := NewCuckoo(DefaultLogSize)
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if c.Meta().Version < uint64(c.Len()) || !d.Meta().Created.Equal(c.Meta().Created) {
+		t.Error("got: ", c.Meta().Version, d.Meta().Created, " expected: >= ", c.Len(), c.Meta().Created)
+	}
+}
+
+func TestChainAlarm(t *testing.T) {
+	c := NewCuckoo(16)
+	c.SetMaxLogSize(16)
+
+	fired := false
+	c.SetChainAlarm(1, func(p99 int) {
+		if p99 <= 1 || c.LoadFactor() < 0.5 {
+			t.Error("unexpected alarm: ", p99, c.LoadFactor())
+		}
+		fired = true
+	})
+
+	for i := 0; i < 1<<16; i++ {
+		c.Insert(gkeys[i], gvals[i])
+	}
+
+	if !fired {
+		t.Error("alarm did not fire")
+	}
+	if c.MaxChain() < c.ChainPercentile(0.99) {
+		t.Error("MaxChain: ", c.MaxChain(), " < p99: ", c.ChainPercentile(0.99))
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	m := NewConcurrent(DefaultLogSize)
+	nworkers := 8
+	nitems := 1 << 16
+
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < nitems; i += nworkers {
+				m.Insert(Key(i), Value(i))
+				if _, ok := m.Search(Key(i)); !ok {
+					t.Error("not found: ", Key(i))
+				}
+			}
+			for i := w; i < nitems; i += 2 * nworkers {
+				m.Delete(Key(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for i := 0; i < nitems; i++ {
+		_, ok := m.Search(Key(i))
+		if deleted := i%(2*nworkers) < nworkers; ok == deleted {
+			t.Error("got: ", ok, " expected: ", !deleted)
 			return
 		}
 	}
+	if m.Len() != nitems/2 {
+		t.Error("got: ", m.Len(), " expected: ", nitems/2)
+	}
+}
 
-	if c.Len() != len(gmap) {
-		t.Error("got: ", c.Len(), " expected: ", len(gmap))
-		return
+func TestProbeSequence(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 1; i <= 1000; i++ {
+		c.Insert(Key(i), Value(i))
 	}
 
-	ndeleted := 0
-	maxdelete := len(gmap) * 95 / 100
-	for k := range gmap {
-		if ndeleted >= maxdelete {
-			break
+	for i := 1; i <= 1000; i++ {
+		probes := c.ProbeSequence(Key(i))
+		if len(probes) != nhash+1 || probes[nhash].Bucket != -1 {
+			t.Error("unexpected probes: ", probes)
+			return
 		}
 
-		c.Delete(k)
-		if v, ok := c.Search(k); ok == true {
-			t.Error("got: ", v)
+		b, _ := c.locate(Key(i))
+		found := b < 0
+		for _, p := range probes[:nhash] {
+			found = found || p.Bucket == b
+		}
+		if !found {
+			t.Error("bucket ", b, " of key ", i, " not in ", probes)
 			return
 		}
+	}
 
-		ndeleted++
+	buf := make([]Probe, 0, NumHash+1)
+	if n := testing.AllocsPerRun(10, func() { c.ProbeSequenceInto(1, buf) }); n != 0 {
+		t.Error("got: ", n, " allocations, expected: ", 0)
+	}
+}
 
-		if c.Len() != len(gmap)-ndeleted {
-			t.Error("got: ", c.Len(), " expected: ", len(gmap)-ndeleted)
+func TestSearchBatch(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 0; i < 1000; i++ {
+		c.Insert(gkeys[i], gvals[i])
+	}
+
+	keys := gkeys[500:1500]
+	vals := make([]Value, len(keys))
+	oks := make([]bool, len(keys))
+	c.SearchBatch(keys, vals, oks)
+	for i, k := range keys {
+		v, ok := c.Search(k)
+		if ok != oks[i] || reflect.DeepEqual(v, vals[i]) == false {
+			t.Error("got: ", vals[i], oks[i], " expected: ", v, ok)
 			return
 		}
 	}
+
+	out := make([]int, len(keys)*NumHash)
+	c.HashBatch(keys, out)
+	for i, k := range keys {
+		var h [nhash]hash
+		c.dohash(k, &h)
+		for j := range h {
+			if out[i*NumHash+j] != int(h[j]) {
+				t.Error("got: ", out[i*NumHash+j], " expected: ", h[j])
+				return
+			}
+		}
+	}
+}
+
+func TestWriteOccupancyPNG(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 0; i < 1000; i++ {
+		c.Insert(gkeys[i], gvals[i])
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteOccupancyPNG(&buf, 16); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx()*b.Dy() != len(c.buckets) {
+		t.Error("got: ", b, " for ", len(c.buckets), " buckets")
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 1; i <= 100; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	c.Insert(0, 1)
+	c.stash.keys[0], c.stash.vals[0] = 1000, 1000
+	c.nentries++
+
+	var buf bytes.Buffer
+	if err := c.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "graph cuckoo {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Fatal("not an undirected graph: ", out)
+	}
+	lines := make(map[string]int)
+	for _, l := range strings.Split(out, "\n") {
+		lines[l]++
+	}
+
+	var h [nhash]hash
+	nsolid, ndashed := 0, 0
+	check := func(k Key, home string) {
+		if lines[fmt.Sprintf("\tk%v -- %v;", k, home)] != 1 {
+			t.Error("no solid edge from ", k, " to ", home)
+		}
+		nsolid++
+
+		c.dohash(k, &h)
+		dashed := make(map[string]bool)
+		for _, hval := range &h {
+			if b := fmt.Sprintf("b%d", hval); b != home {
+				dashed[b] = true
+			}
+		}
+		for b := range dashed {
+			if lines[fmt.Sprintf("\tk%v -- %v [style=dashed];", k, b)] != 1 {
+				t.Error("no dashed edge from ", k, " to ", b)
+			}
+		}
+		ndashed += len(dashed)
+	}
+	for i := 1; i <= 100; i++ {
+		b, _ := c.locate(Key(i))
+		check(Key(i), fmt.Sprintf("b%d", b))
+	}
+	check(1000, "stash")
+
+	// key 0 is kept aside and has no bucket, so it is not drawn.
+	if strings.Contains(out, "k0 ") {
+		t.Error("key 0 drawn")
+	}
+	if n := strings.Count(out, " -- ") - strings.Count(out, "[style=dashed]"); n != nsolid {
+		t.Error("got: ", n, " solid edges, expected: ", nsolid)
+	}
+	if n := strings.Count(out, "[style=dashed]"); n != ndashed {
+		t.Error("got: ", n, " dashed edges, expected: ", ndashed)
+	}
+}
+
+func TestDump(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 1; i <= 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	c.Insert(0, 7)
+	c.stash.keys[0], c.stash.vals[0] = 5000, 5000
+	c.nentries++
+
+	var buf bytes.Buffer
+	if err := c.DumpTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !strings.HasPrefix(lines[0], fmt.Sprintf("cuckoo: len=%d ", c.Len())) || !strings.HasSuffix(lines[0], " zero=7") {
+		t.Error("unexpected summary: ", lines[0])
+	}
+	if last := lines[len(lines)-1]; last != "stash: 5000=5000" {
+		t.Error("got: ", last, " expected: ", "stash: 5000=5000")
+	}
+
+	// every item is listed once, under its bucket, with the buckets in increasing order.
+	seen := make(map[Key]bool)
+	prev := -1
+	for _, l := range lines[1 : len(lines)-1] {
+		var bi int
+		if _, err := fmt.Sscanf(l, "%d:", &bi); err != nil || bi <= prev {
+			t.Fatal("unexpected line: ", l)
+		}
+		prev = bi
+		for _, item := range strings.Fields(l)[1:] {
+			var k Key
+			var v Value
+			if _, err := fmt.Sscanf(item, "%v=%v", &k, &v); err != nil || v != Value(k) {
+				t.Fatal("unexpected item: ", item)
+			}
+			if b, _ := c.locate(k); b != bi || seen[k] {
+				t.Error("misplaced item: ", item, " in bucket ", bi)
+			}
+			seen[k] = true
+		}
+	}
+	if len(seen) != 1000 {
+		t.Error("got: ", len(seen), " items, expected: ", 1000)
+	}
+
+	// String lists only the first stringBuckets buckets, the same way.
+	lines = strings.Split(strings.TrimSuffix(c.String(), "\n"), "\n")
+	if len(lines) != stringBuckets+3 || lines[stringBuckets+1] != "..." || lines[stringBuckets+2] != "stash: 5000=5000" {
+		t.Error("unexpected truncation: ", lines)
+	}
+	if !strings.HasPrefix(buf.String(), strings.Join(lines[:stringBuckets+1], "\n")) {
+		t.Error("String and DumpTo disagree")
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := NewCuckoo(0)
+	for i := 0; i < 10000; i++ {
+		c.Insert(Key(i), Value(i))
+		c.Search(Key(i))
+	}
+	c.Delete(1)
+
+	s := c.Stats()
+	if s.Len != c.Len() || s.Cap != c.Cap() || s.Grows == 0 || s.Kicks == 0 {
+		t.Error("unexpected stats: ", s)
+	}
+
+	if instrument {
+		if s.Insert.Count() != 10000 || s.Search.Count() != 10000 || s.Delete.Count() != 1 {
+			t.Error("unexpected latency counts: ", s.Insert.Count(), s.Search.Count(), s.Delete.Count())
+		}
+		if s.Search.Quantile(0.5) > s.Search.Quantile(0.99) {
+			t.Error("median above 99th percentile")
+		}
+		c.ResetLatencies()
+		if s := c.Stats(); s.Insert.Count() != 0 {
+			t.Error("latencies not reset")
+		}
+	}
+
+	placed := 0
+	p := c.Placement()
+	for _, n := range p {
+		placed += n
+	}
+	for _, k := range c.stash.keys {
+		if k != 0 {
+			placed++
+		}
+	}
+	if placed != c.Len()-1 || p[0] < p[NumHash-1] { // key 0 is kept aside.
+		t.Error("unexpected placement: ", p, placed, c.Len())
+	}
+
+	m := NewConcurrent(0)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < 10000; i += 4 {
+				m.Insert(Key(i), Value(i))
+				m.Search(Key(i))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	s = m.Stats()
+	if s.Len != 10000 || s.Len != m.Len() || s.LoadFactor <= 0 || s.LoadFactor > 1 {
+		t.Error("unexpected stats: ", s)
+	}
+	n := 0
+	for _, ss := range m.ShardStats() {
+		n += ss.Len
+	}
+	if n != s.Len {
+		t.Error("got: ", n, " expected: ", s.Len)
+	}
+	if instrument && s.Search.Count() != 10000 {
+		t.Error("got: ", s.Search.Count(), " expected: ", 10000)
+	}
+}
+
+func TestSkewHandler(t *testing.T) {
+	// a healthy hash function never trips the handler while the table grows.
+	c := NewCuckoo(0)
+	c.SetSkewHandler(4, func(counts [NumHash]int) {
+		t.Error("unexpected skew: ", counts)
+	})
+	for i := 0; i < 100000; i++ {
+		c.Insert(gkeys[i], gvals[i])
+	}
+
+	// functions with an even seed send every key to the same bucket.
+	var skewed [NumHash]int
+	c.SetSkewHandler(4, func(counts [NumHash]int) {
+		skewed = counts
+	})
+	c.SetHash(func(k Key, seed uint32) uint32 {
+		if seed&1 == 0 {
+			return seed
+		}
+		h := (uint32(k) ^ seed) * 0x9e3779b1
+		return h ^ h>>15
+	})
+	for i := 0; i < 50 && skewed == [NumHash]int{}; i++ {
+		c.Rehash()
+	}
+	if skewed == [NumHash]int{} {
+		t.Fatal("skew not reported")
+	}
+	if skewed != c.Placement() {
+		t.Error("got: ", skewed, " expected: ", c.Placement())
+	}
+}
+
+func TestFlood(t *testing.T) {
+	c := NewCuckoo(16)
+	for i := range c.seed {
+		c.seed[i] = c.seed[0] // all candidate buckets of a key coincide.
+	}
+
+	// an attacker who knows the seeds picks keys that all land in bucket 0.
+	var keys []Key
+	var h [nhash]hash
+	for k := Key(1); len(keys) < 2*(blen+stashSize); k++ {
+		if c.dohash(k, &h); h[0] == 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	flooded := false
+	c.SetFloodHandler(func(load float64) {
+		flooded = true
+	})
+	for _, k := range keys {
+		c.Insert(k, Value(k))
+	}
+
+	if !flooded || c.Stats().Floods == 0 {
+		t.Error("flooding not detected")
+	}
+	for _, k := range keys {
+		if v, ok := c.Search(k); !ok || v != Value(k) {
+			t.Error("got: ", v, ok, " expected: ", k)
+		}
+	}
 }
 
 func TestMem(t *testing.T) {
@@ -160,6 +1826,23 @@ func BenchmarkCuckooInsert(b *testing.B) {
 	}
 }
 
+func BenchmarkCuckooBulkLoad(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		BulkLoad(gkeys[:1<<20], gvals[:1<<20])
+	}
+}
+
+func BenchmarkCuckooInsertAll(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c := NewCuckoo(LogSizeFor(1 << 20))
+		for j, k := range gkeys[:1<<20] {
+			c.Insert(k, gvals[j])
+		}
+	}
+}
+
 func BenchmarkCuckooSearch(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
@@ -169,6 +1852,18 @@ func BenchmarkCuckooSearch(b *testing.B) {
 	}
 }
 
+func BenchmarkCuckooSearchBatch(b *testing.B) {
+	vals := make([]Value, searchBatch)
+	oks := make([]bool, searchBatch)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i += searchBatch {
+		j := i % (n - searchBatch)
+		cbench.SearchBatch(gkeys[j:j+searchBatch], vals, oks)
+	}
+}
+
 func BenchmarkCuckooDelete(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
@@ -205,3 +1900,28 @@ func BenchmarkMapDelete(b *testing.B) {
 		delete(mbench, gkeys[i%n])
 	}
 }
+
+var concbench *Concurrent
+
+func BenchmarkConcurrentInsert(b *testing.B) {
+	concbench = NewConcurrent(logsize)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := rand.Int(); pb.Next(); i++ {
+			concbench.Insert(gkeys[i%n], gvals[i%n])
+		}
+	})
+}
+
+func BenchmarkConcurrentSearch(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := rand.Int(); pb.Next(); i++ {
+			concbench.Search(gkeys[i%n])
+		}
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package cuckoocache implements the doorkeeper admission pattern (as in TinyLFU) in front of an arbitrary cache,
+// using cuckoo hash tables to remember recently seen keys.
+//
+// By default, a key is admitted into the cache only when it is Set for the second time within a window of recent keys.
+// Keys seen only once ("one-hit wonders") never reach the cache and hence never evict anything useful.
+// NewThreshold raises the number of sightings required for admission.
+package cuckoocache
+
+import (
+	"github.com/salviati/cuckoo"
+)
+
+// Cache is the interface of the cache guarded by a Doorkeeper.
+type Cache interface {
+	Get(k cuckoo.Key) (cuckoo.Value, bool)
+	Set(k cuckoo.Key, v cuckoo.Value)
+}
+
+// Doorkeeper wraps a Cache and filters what is admitted into it.
+// Similar to Cuckoo, a Doorkeeper is not thread-safe.
+type Doorkeeper struct {
+	cache     Cache
+	window    int
+	threshold int
+
+	// keys seen (but not yet admitted), in the current and the previous window.
+	// when cur fills up, it becomes prev and the old prev is forgotten.
+	cur, prev generation
+
+	nadmitted int
+	nrejected int
+}
+
+// generation counts sightings without needing a numeric Value: g[i] holds the keys seen i+1 times.
+// A key is in at most one table of a generation.
+type generation []*cuckoo.Cuckoo
+
+func newGeneration(levels, window int) generation {
+	g := make(generation, levels)
+	for i := range g {
+		g[i] = newSeen(window)
+	}
+	return g
+}
+
+// count returns the number of times k was seen in g.
+func (g generation) count(k cuckoo.Key) int {
+	for i := len(g) - 1; i >= 0; i-- {
+		if _, ok := g[i].Search(k); ok {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// forget removes k, seen n times, from g.
+func (g generation) forget(k cuckoo.Key, n int) {
+	if n > 0 {
+		g[n-1].Delete(k)
+	}
+}
+
+func (g generation) Len() int {
+	n := 0
+	for _, t := range g {
+		n += t.Len()
+	}
+	return n
+}
+
+// New returns a Doorkeeper guarding cache, which remembers keys seen once for a window of at least window distinct keys.
+// Keys are admitted on their second sighting.
+func New(cache Cache, window int) *Doorkeeper {
+	return NewThreshold(cache, window, 2)
+}
+
+// NewThreshold is like New, but keys are admitted into the cache only once they are seen threshold times
+// within the window. A threshold of 1 or less admits everything.
+func NewThreshold(cache Cache, window, threshold int) *Doorkeeper {
+	if window <= 0 {
+		window = 1 << cuckoo.DefaultLogSize
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	return &Doorkeeper{
+		cache:     cache,
+		window:    window,
+		threshold: threshold,
+		cur:       newGeneration(threshold-1, window),
+		prev:      newGeneration(threshold-1, 0),
+	}
+}
+
+func newSeen(window int) *cuckoo.Cuckoo {
+	return cuckoo.NewCuckoo(cuckoo.LogSizeFor(window))
+}
+
+// Get looks k up in the cache.
+func (d *Doorkeeper) Get(k cuckoo.Key) (cuckoo.Value, bool) {
+	return d.cache.Get(k)
+}
+
+// Set stores k/v in the cache if k is already cached or if this makes threshold sightings of k;
+// otherwise k is only remembered. It returns whether k/v was passed to the cache.
+func (d *Doorkeeper) Set(k cuckoo.Key, v cuckoo.Value) bool {
+	if _, ok := d.cache.Get(k); ok || d.seen(k) {
+		d.cache.Set(k, v)
+		d.nadmitted++
+		return true
+	}
+
+	d.nrejected++
+	return false
+}
+
+// GetOrLoad returns the cached value of k, or calls load on a miss and offers the loaded value to Set.
+func (d *Doorkeeper) GetOrLoad(k cuckoo.Key, load func(cuckoo.Key) (cuckoo.Value, error)) (cuckoo.Value, error) {
+	if v, ok := d.cache.Get(k); ok {
+		return v, nil
+	}
+
+	v, err := load(k)
+	if err != nil {
+		return v, err
+	}
+
+	d.Set(k, v)
+	return v, nil
+}
+
+// Stats returns the number of Set calls that were admitted to and rejected from the cache.
+func (d *Doorkeeper) Stats() (admitted, rejected int) {
+	return d.nadmitted, d.nrejected
+}
+
+// seen records a sighting of k, and reports whether k has now been seen threshold times.
+// Admitted keys are forgotten.
+func (d *Doorkeeper) seen(k cuckoo.Key) bool {
+	n := d.cur.count(k)
+	if n > 0 {
+		d.cur.forget(k, n)
+	} else {
+		n = d.prev.count(k)
+		d.prev.forget(k, n)
+	}
+
+	n++
+	if n >= d.threshold {
+		return true
+	}
+
+	d.remember(k, n)
+	return false
+}
+
+// remember records that k has been seen n times.
+func (d *Doorkeeper) remember(k cuckoo.Key, n int) {
+	if d.cur.Len() >= d.window {
+		d.prev = d.cur
+		d.cur = newGeneration(d.threshold-1, d.window)
+	}
+
+	var v cuckoo.Value
+	d.cur[n-1].Insert(k, v)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoocache
+
+import (
+	"testing"
+
+	"github.com/salviati/cuckoo"
+)
+
+type mapCache map[cuckoo.Key]cuckoo.Value
+
+func (m mapCache) Get(k cuckoo.Key) (cuckoo.Value, bool) { v, ok := m[k]; return v, ok }
+func (m mapCache) Set(k cuckoo.Key, v cuckoo.Value)      { m[k] = v }
+
+func TestDoorkeeper(t *testing.T) {
+	m := make(mapCache)
+	d := New(m, 100)
+
+	for k := cuckoo.Key(1); k <= 1000; k++ {
+		if d.Set(k, cuckoo.Value(k)) {
+			t.Error("one-hit key admitted:", k)
+		}
+	}
+	if len(m) != 0 {
+		t.Error("got: ", len(m), " expected: ", 0)
+	}
+
+	// the last window of keys is still remembered.
+	for k := cuckoo.Key(901); k <= 1000; k++ {
+		if !d.Set(k, cuckoo.Value(k)) {
+			t.Error("second-hit key rejected:", k)
+		}
+		if v, ok := d.Get(k); !ok || v != cuckoo.Value(k) {
+			t.Error("got: ", v, ok, " expected: ", k)
+		}
+	}
+
+	// the first keys are long forgotten.
+	if d.Set(1, 1) {
+		t.Error("forgotten key admitted")
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	m := make(mapCache)
+	d := NewThreshold(m, 100, 3)
+
+	for i := 1; i <= 2; i++ {
+		for k := cuckoo.Key(1); k <= 50; k++ {
+			if d.Set(k, cuckoo.Value(k)) {
+				t.Error("key admitted after", i, "sightings:", k)
+			}
+		}
+	}
+	for k := cuckoo.Key(1); k <= 50; k++ {
+		if !d.Set(k, cuckoo.Value(k)) {
+			t.Error("third-hit key rejected:", k)
+		}
+	}
+
+	if admitted, rejected := d.Stats(); admitted != 50 || rejected != 100 {
+		t.Error("got: ", admitted, rejected, " expected: ", 50, 100)
+	}
+
+	d = NewThreshold(make(mapCache), 100, 1)
+	if !d.Set(1, 1) {
+		t.Error("threshold 1 rejected a key")
+	}
+}
+
+func TestWindowSize(t *testing.T) {
+	const window = 100000
+	d := New(make(mapCache), window)
+	for k := cuckoo.Key(1); k < window; k++ {
+		d.Set(k, cuckoo.Value(k))
+	}
+	// a generation is sized for its window upfront, so it never grows before it is rotated.
+	if s := d.cur[0].Stats(); s.Len != window-1 || s.Grows != 0 {
+		t.Error("got: ", s.Len, s.Grows, " expected: ", window-1, 0)
+	}
+}
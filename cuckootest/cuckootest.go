@@ -0,0 +1,216 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package cuckootest implements model-based testing helpers for package cuckoo.
+// Every operation is applied both to a Cuckoo and to a built-in map (the model), and
+// any divergence between the two is reported as an error.
+// Decode turns arbitrary bytes into an operation sequence, which makes the harness
+// easy to drive from a fuzzer.
+package cuckootest
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/salviati/cuckoo"
+)
+
+// Kind is the type of an operation.
+type Kind uint8
+
+const (
+	Insert Kind = iota
+	Delete
+	Search
+	nkinds
+)
+
+// Op is a single operation applied by Model.Apply.
+type Op struct {
+	Kind  Kind
+	Key   cuckoo.Key
+	Value cuckoo.Value // used by Insert only.
+}
+
+// Decode turns data into a sequence of operations, 3 bytes per operation:
+// one for the kind and two for the key. Keys are kept in a small range on purpose,
+// so that a sequence hits the same keys (and the zero key) often.
+// If value is not nil, it supplies the value of each Insert.
+func Decode(data []byte, value func(cuckoo.Key) cuckoo.Value) []Op {
+	ops := make([]Op, 0, len(data)/3)
+	for ; len(data) >= 3; data = data[3:] {
+		op := Op{
+			Kind: Kind(data[0] % byte(nkinds)),
+			Key:  cuckoo.Key(uint16(data[1]) | uint16(data[2])<<8),
+		}
+		if op.Kind == Insert && value != nil {
+			op.Value = value(op.Key)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// Model pairs a Cuckoo with a built-in map holding the same items.
+type Model struct {
+	C *cuckoo.Cuckoo
+	M map[cuckoo.Key]cuckoo.Value
+}
+
+// NewModel creates a Model with an empty Cuckoo of the given logsize.
+func NewModel(logsize int) *Model {
+	return &Model{
+		C: cuckoo.NewCuckoo(logsize),
+		M: make(map[cuckoo.Key]cuckoo.Value),
+	}
+}
+
+// Apply applies op to both the Cuckoo and the model.
+// For Search, the results of both are compared.
+func (m *Model) Apply(op Op) error {
+	switch op.Kind {
+	case Insert:
+		m.C.Insert(op.Key, op.Value)
+		m.M[op.Key] = op.Value
+	case Delete:
+		m.C.Delete(op.Key)
+		delete(m.M, op.Key)
+	case Search:
+		v, ok := m.C.Search(op.Key)
+		mv, mok := m.M[op.Key]
+		if ok != mok {
+			return fmt.Errorf("cuckootest: Search(%v) reported ok=%v, expected %v", op.Key, ok, mok)
+		}
+		if ok && reflect.DeepEqual(v, mv) == false {
+			return fmt.Errorf("cuckootest: Search(%v) = %v, expected %v", op.Key, v, mv)
+		}
+	default:
+		return fmt.Errorf("cuckootest: unknown operation kind %d", op.Kind)
+	}
+	return nil
+}
+
+// Run applies ops in order, checking the invariants after each operation.
+// It stops at the first error.
+func (m *Model) Run(ops []Op) error {
+	for i, op := range ops {
+		if err := m.Apply(op); err != nil {
+			return fmt.Errorf("op #%d: %v", i, err)
+		}
+		if err := m.Check(); err != nil {
+			return fmt.Errorf("op #%d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Check verifies that the Cuckoo holds exactly the items in the model:
+// Len must match, every item of the model must be found by Search,
+// and ForRange must visit every item exactly once.
+func (m *Model) Check() error {
+	if m.C.Len() != len(m.M) {
+		return fmt.Errorf("cuckootest: Len() = %d, expected %d", m.C.Len(), len(m.M))
+	}
+
+	for k, mv := range m.M {
+		v, ok := m.C.Search(k)
+		if !ok {
+			return fmt.Errorf("cuckootest: key %v is missing", k)
+		}
+		if reflect.DeepEqual(v, mv) == false {
+			return fmt.Errorf("cuckootest: key %v has value %v, expected %v", k, v, mv)
+		}
+	}
+
+	var err error
+	seen := make(map[cuckoo.Key]bool, len(m.M))
+	m.C.ForRange(func(k cuckoo.Key, v cuckoo.Value) {
+		if err != nil {
+			return
+		}
+		if seen[k] {
+			err = fmt.Errorf("cuckootest: ForRange visited key %v twice", k)
+			return
+		}
+		seen[k] = true
+		if _, ok := m.M[k]; !ok {
+			err = fmt.Errorf("cuckootest: ForRange visited unexpected key %v", k)
+		}
+	})
+	return err
+}
+
+// Verified wraps a hash map for use in integration environments: every operation is also applied to an exact model,
+// and every Search is checked against it. Items dropped due to a size limit (see Cuckoo.SetMaxLogSize) count as errors too.
+type Verified struct {
+	m     cuckoo.Map
+	model map[cuckoo.Key]cuckoo.Value
+
+	// OnError is called with every divergence from the model. If nil, Verified panics instead.
+	OnError func(error)
+}
+
+var _ cuckoo.Map = (*Verified)(nil)
+
+// NewVerified returns a Verified wrapping m, which must be empty.
+func NewVerified(m cuckoo.Map) *Verified {
+	return &Verified{m: m, model: make(map[cuckoo.Key]cuckoo.Value)}
+}
+
+func (v *Verified) fail(err error) {
+	if v.OnError == nil {
+		panic(err)
+	}
+	v.OnError(err)
+}
+
+// Search looks k up in the wrapped hash map, checking the result against the model.
+func (v *Verified) Search(k cuckoo.Key) (cuckoo.Value, bool) {
+	val, ok := v.m.Search(k)
+	mval, mok := v.model[k]
+	switch {
+	case ok != mok:
+		v.fail(fmt.Errorf("cuckootest: Search(%v) reported ok=%v, expected %v", k, ok, mok))
+	case ok && reflect.DeepEqual(val, mval) == false:
+		v.fail(fmt.Errorf("cuckootest: Search(%v) = %v, expected %v", k, val, mval))
+	}
+	return val, ok
+}
+
+// Insert adds k/val to the wrapped hash map and to the model.
+func (v *Verified) Insert(k cuckoo.Key, val cuckoo.Value) {
+	v.m.Insert(k, val)
+	v.model[k] = val
+}
+
+// Delete removes k from the wrapped hash map and from the model.
+func (v *Verified) Delete(k cuckoo.Key) {
+	v.m.Delete(k)
+	delete(v.model, k)
+}
+
+// Len returns the number of items in the wrapped hash map, checking it against the model.
+func (v *Verified) Len() int {
+	n := v.m.Len()
+	if n != len(v.model) {
+		v.fail(fmt.Errorf("cuckootest: Len() = %d, expected %d", n, len(v.model)))
+	}
+	return n
+}
+
+// ForRange calls f on the items of the wrapped hash map.
+func (v *Verified) ForRange(f func(cuckoo.Key, cuckoo.Value)) {
+	v.m.ForRange(f)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckootest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/salviati/cuckoo"
+)
+
+func value(k cuckoo.Key) cuckoo.Value {
+	return cuckoo.Value(k) + 1
+}
+
+func TestRandom(t *testing.T) {
+	data := make([]byte, 3*10000)
+	rand.Read(data)
+
+	m := NewModel(0)
+	if err := m.Run(Decode(data, value)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func FuzzModel(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 2, 0, 0, 1, 0, 0, 2, 0, 0})
+	f.Add([]byte{0, 1, 2, 0, 3, 4, 1, 1, 2, 2, 3, 4})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := NewModel(0)
+		if err := m.Run(Decode(data, value)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestVerified(t *testing.T) {
+	v := NewVerified(cuckoo.NewCuckoo(cuckoo.DefaultLogSize))
+	for i := 0; i < 10000; i++ {
+		k := cuckoo.Key(rand.Intn(1000))
+		if rand.Intn(3) == 0 {
+			v.Delete(k)
+		} else {
+			v.Insert(k, value(k))
+		}
+		v.Search(cuckoo.Key(rand.Intn(1000)))
+	}
+	v.Len()
+
+	c := cuckoo.NewCuckoo(cuckoo.DefaultLogSize)
+	v = NewVerified(c)
+	v.Insert(1, 1)
+	c.Delete(1) // behind the back of Verified.
+
+	var errs []error
+	v.OnError = func(err error) { errs = append(errs, err) }
+	v.Search(1)
+	v.Len()
+	if len(errs) != 2 {
+		t.Error("got: ", errs, " expected 2 errors")
+	}
+
+	v.OnError = nil
+	defer func() {
+		if recover() == nil {
+			t.Error("false negative did not panic")
+		}
+	}()
+	v.Search(1)
+}
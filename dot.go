@@ -0,0 +1,88 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteDOT writes the cuckoo graph of the hash map to w in Graphviz DOT format.
+// Every non-empty bucket and every key is a node. A key is connected to the bucket (or stash) it is stored in with a solid edge,
+// and to its other candidate buckets with dashed edges; these are the moves available to the random walk.
+// The output grows with the number of items, so this is meant for small tables.
+func (c *Cuckoo) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "graph cuckoo {")
+	fmt.Fprintln(bw, "\trankdir=LR;")
+
+	var h [nhash]hash
+	// home is the index of the bucket k is stored in, or -1 for the stash.
+	edges := func(k Key, home int) {
+		fmt.Fprintf(bw, "\tk%v [label=\"%v\"];\n", k, k)
+		if home < 0 {
+			fmt.Fprintf(bw, "\tk%v -- stash;\n", k)
+		} else {
+			fmt.Fprintf(bw, "\tk%v -- b%d;\n", k, home)
+		}
+
+		c.dohash(k, &h)
+	next:
+		for i, hval := range &h {
+			if int(hval) == home {
+				continue
+			}
+			for _, prev := range h[:i] {
+				if prev == hval {
+					continue next
+				}
+			}
+			fmt.Fprintf(bw, "\tk%v -- b%d [style=dashed];\n", k, hval)
+		}
+	}
+
+	for bi := range c.buckets {
+		b := &c.buckets[bi]
+		used := 0
+		for _, key := range &b.keys {
+			if key != 0 {
+				used++
+			}
+		}
+		if used == 0 {
+			continue
+		}
+
+		fmt.Fprintf(bw, "\tb%d [shape=box, label=\"bucket %d (%d/%d)\"];\n", bi, bi, used, blen)
+		for _, key := range &b.keys {
+			if key != 0 {
+				edges(key, bi)
+			}
+		}
+	}
+
+	fmt.Fprintln(bw, "\tstash [shape=box];")
+	for _, key := range c.stash.keys {
+		if key != 0 {
+			edges(key, -1)
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// String returns a summary of the hash map followed by the contents of the first few non-empty buckets (in bucket order) and the stash.
+func (c *Cuckoo) String() string {
+	var sb strings.Builder
+	c.dump(&sb, stringBuckets)
+	return sb.String()
+}
+
+// DumpTo writes the contents of every non-empty bucket (in bucket order) and the stash to w, one line each.
+func (c *Cuckoo) DumpTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	c.dump(bw, -1)
+	return bw.Flush()
+}
+
+// dump writes at most max non-empty buckets to w; all of them if max < 0.
+func (c *Cuckoo) dump(w io.Writer, max int) {
+	fmt.Fprintf(w, "cuckoo: len=%d buckets=%d load=%.4f", c.nentries, len(c.buckets), c.LoadFactor())
+	if c.zeroIsSet {
+		fmt.Fprintf(w, " zero=%v", c.zeroValue)
+	}
+	fmt.Fprintln(w)
+
+	nprinted := 0
+	for bi := range c.buckets {
+		b := &c.buckets[bi]
+		empty := true
+		for _, key := range &b.keys {
+			if key != 0 {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			continue
+		}
+
+		if nprinted == max {
+			fmt.Fprintln(w, "...")
+			break
+		}
+		nprinted++
+
+		fmt.Fprintf(w, "%d:", bi)
+		for i, key := range &b.keys {
+			if key != 0 {
+				fmt.Fprintf(w, " %v=%v", key, b.vals[i])
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprint(w, "stash:")
+	for i, key := range c.stash.keys {
+		if key != 0 {
+			fmt.Fprintf(w, " %v=%v", key, c.stash.vals[i])
+		}
+	}
+	fmt.Fprintln(w)
+}
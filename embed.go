@@ -0,0 +1,40 @@
+//go:build go1.16
+
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"io/fs"
+)
+
+// LoadEmbedded creates a hash map from the file at path in fsys, which must have been written by WriteTo.
+// This is meant for prebuilt tables shipped within the binary using go:embed.
+// The header of the file is validated as in ReadFrom; a file written with different Key/Value types is rejected with ErrFormat.
+func LoadEmbedded(fsys fs.FS, path string) (*Cuckoo, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := NewCuckoo(DefaultLogSize)
+	if _, err := c.ReadFrom(f); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
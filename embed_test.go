@@ -0,0 +1,59 @@
+//go:build go1.16
+
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	c := NewCuckoo(DefaultLogSize)
+	for i := 0; i < 10000; i++ {
+		c.Insert(gkeys[i], gvals[i])
+	}
+
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	fsys := fstest.MapFS{
+		"deny.ckoo": &fstest.MapFile{Data: buf.Bytes()},
+		"bad.ckoo":  &fstest.MapFile{Data: []byte("garbage garbage garbage")},
+	}
+
+	e, err := LoadEmbedded(fsys, "deny.ckoo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Len() != c.Len() {
+		t.Error("got: ", e.Len(), " expected: ", c.Len())
+	}
+	c.ForRange(func(k Key, v Value) {
+		if ev, ok := e.Search(k); !ok || ev != v {
+			t.Error("got: ", ev, ok, " expected: ", v)
+		}
+	})
+
+	if _, err := LoadEmbedded(fsys, "bad.ckoo"); !errors.Is(err, ErrFormat) {
+		t.Error("got: ", err, " expected: ", ErrFormat)
+	}
+	if _, err := LoadEmbedded(fsys, "missing.ckoo"); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
@@ -0,0 +1,463 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Encoding format. All integers are little-endian regardless of the architecture, so an encoding can be read anywhere.
+//
+//	magic     [4]byte  "CKOO"
+//	version   uint8    encVersion
+//	byteOrder uint8    'L', for little-endian
+//	keySize   uint8    binary.Size of Key
+//	valSize   uint32   binary.Size of Value
+//	count     uint64   number of items
+//	created   int64    creation time of the hash map in Unix nanoseconds, 0 if unknown   (since version 3)
+//	mversion  uint64   version counter of the hash map, see Meta                         (since version 3)
+//	nlabels   uint32   number of labels                                                  (since version 3)
+//	labels    nlabels × (klen uint32, key [klen]byte, vlen uint32, value [vlen]byte), sorted by key (since version 3)
+//	items     count × (key, value), sorted by key
+//	checksum  uint32   CRC-32C (Castagnoli) of everything above
+//
+// Items are sorted so that the encoding depends only on the contents (and metadata) of the hash map;
+// the layout of the table (its size, seeds, and which cell holds which item) is not part of it.
+// WriteContentTo writes created and mversion as 0, so that two hash maps holding the same items and labels
+// encode to identical bytes, however and whenever they were built.
+// Version 2 encodings, which have no metadata, can still be read.
+
+const (
+	encMagic      = "CKOO"
+	encVersion    = 3
+	encMinVersion = 2 // oldest version ReadFrom understands.
+	encByteOrder  = 'L'
+	encBlock      = 1024          // number of items encoded/decoded at once.
+	encSegment    = 64 * encBlock // number of items encoded by one goroutine when encoding in parallel.
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrFormat is matched (with errors.Is) by every *FormatError.
+var ErrFormat = errors.New("cuckoo: invalid or incompatible encoding")
+
+// ErrDropped is returned by ReadFrom when items were dropped because the table could not grow (see SetMaxLogSize and SetGrowHandler).
+var ErrDropped = errors.New("cuckoo: items dropped at the size limit")
+
+// FormatError is returned by ReadFrom when its input is not an encoded hash map, was encoded by an incompatible build
+// (different version or Key/Value types), or is corrupted.
+type FormatError struct {
+	Field     string // header field that did not match, "checksum", or "size" (see NewSnapshotReader).
+	Got, Want uint64
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("cuckoo: invalid or incompatible encoding: %s is %#x, expected %#x", e.Field, e.Got, e.Want)
+}
+
+// Is reports whether target is ErrFormat.
+func (e *FormatError) Is(target error) bool {
+	return target == ErrFormat
+}
+
+type item struct {
+	Key   Key
+	Value Value
+}
+
+type encHeader struct {
+	Magic     [4]byte
+	Version   uint8
+	ByteOrder uint8
+	KeySize   uint8
+	ValSize   uint32
+	Count     uint64
+}
+
+func newHeader(count uint64) encHeader {
+	h := encHeader{
+		Version:   encVersion,
+		ByteOrder: encByteOrder,
+		KeySize:   uint8(binary.Size(Key(0))),
+		ValSize:   uint32(binary.Size(zero)),
+		Count:     count,
+	}
+	copy(h.Magic[:], encMagic)
+	return h
+}
+
+// check returns a *FormatError describing the first field of h which doesn't match what this build writes.
+func (h *encHeader) check() error {
+	want := newHeader(h.Count)
+	switch {
+	case h.Magic != want.Magic:
+		return &FormatError{"magic", uint64(binary.LittleEndian.Uint32(h.Magic[:])), uint64(binary.LittleEndian.Uint32(want.Magic[:]))}
+	case h.Version < encMinVersion || h.Version > want.Version:
+		return &FormatError{"version", uint64(h.Version), uint64(want.Version)}
+	case h.ByteOrder != want.ByteOrder:
+		return &FormatError{"byte order", uint64(h.ByteOrder), uint64(want.ByteOrder)}
+	case h.KeySize != want.KeySize:
+		return &FormatError{"key size", uint64(h.KeySize), uint64(want.KeySize)}
+	case h.ValSize != want.ValSize:
+		return &FormatError{"value size", uint64(h.ValSize), uint64(want.ValSize)}
+	}
+	return nil
+}
+
+// items returns all items of c for which pred returns true (all of them if pred is nil), sorted by key.
+func (c *Cuckoo) items(pred func(Key, Value) bool) []item {
+	var items []item
+	if pred == nil {
+		items = make([]item, 0, c.nentries)
+	}
+	c.ForRange(func(k Key, v Value) {
+		if pred == nil || pred(k, v) {
+			items = append(items, item{k, v})
+		}
+	})
+	sortItems(items)
+	return items
+}
+
+// sortItems sorts items by key, which is the order of the encoding.
+// Large slices are cut into parts which are sorted on separate goroutines and then merged pairwise, also in parallel;
+// this takes a temporary copy of the items.
+func sortItems(items []item) {
+	nparts := 1
+	for nparts*2 <= runtime.GOMAXPROCS(0) {
+		nparts *= 2
+	}
+	if nparts == 1 || len(items) < parallelSort {
+		sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+		return
+	}
+
+	bounds := make([]int, nparts+1)
+	for i := range bounds {
+		bounds[i] = len(items) * i / nparts
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < nparts; i++ {
+		wg.Add(1)
+		go func(part []item) {
+			defer wg.Done()
+			sort.Slice(part, func(i, j int) bool { return part[i].Key < part[j].Key })
+		}(items[bounds[i]:bounds[i+1]])
+	}
+	wg.Wait()
+
+	src, dst := items, make([]item, len(items))
+	for width := 1; width < nparts; width *= 2 {
+		for i := 0; i < nparts; i += 2 * width {
+			lo, mid, hi := bounds[i], bounds[i+width], bounds[i+2*width]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mergeItems(dst[lo:hi], src[lo:mid], src[mid:hi])
+			}()
+		}
+		wg.Wait()
+		src, dst = dst, src
+	}
+	if &src[0] != &items[0] {
+		copy(items, src)
+	}
+}
+
+// mergeItems merges the sorted slices a and b into dst, which must be as long as both.
+func mergeItems(dst, a, b []item) {
+	i := 0
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].Key < b[0].Key {
+			dst[i], a = a[0], a[1:]
+		} else {
+			dst[i], b = b[0], b[1:]
+		}
+		i++
+	}
+	i += copy(dst[i:], a)
+	copy(dst[i:], b)
+}
+
+// countingWriter keeps track of the number of bytes written, for io.WriterTo.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteTo writes the items of c to w in a deterministic binary format, which can be read back with ReadFrom.
+// Key and Value must be fixed-size types (in the sense of encoding/binary).
+// Large hash maps are sorted and encoded on up to GOMAXPROCS goroutines; the output is the same either way.
+func (c *Cuckoo) WriteTo(w io.Writer) (int64, error) {
+	meta := c.Meta()
+	return writeItems(w, c.items(nil), &meta)
+}
+
+// WriteContentTo is like WriteTo, but writes the creation time and version counter of the metadata as unknown, keeping only
+// the labels. The output thus depends on the items and labels alone: hash maps built independently from the same items
+// encode to identical bytes, as needed for content-addressed storage and caching of build artifacts.
+func (c *Cuckoo) WriteContentTo(w io.Writer) (int64, error) {
+	meta := c.Meta()
+	meta.Created, meta.Version = time.Time{}, 0
+	return writeItems(w, c.items(nil), &meta)
+}
+
+// ExportWhere is like WriteTo, but only writes the items for which pred returns true.
+// The output is an ordinary encoding, which can be read back with ReadFrom.
+func (c *Cuckoo) ExportWhere(w io.Writer, pred func(Key, Value) bool) (int64, error) {
+	meta := c.Meta()
+	return writeItems(w, c.items(pred), &meta)
+}
+
+func writeItems(w io.Writer, items []item, meta *Meta) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	crc := crc32.New(crcTable)
+	hw := io.MultiWriter(bw, crc)
+
+	if err := binary.Write(hw, binary.LittleEndian, newHeader(uint64(len(items)))); err != nil {
+		return cw.n, err
+	}
+	if err := writeMeta(hw, meta); err != nil {
+		return cw.n, err
+	}
+
+	if err := encodeItems(hw, items); err != nil {
+		return cw.n, err
+	}
+
+	sum := crc.Sum32()
+	if faultCorrupt() {
+		sum ^= 1
+	}
+	if err := binary.Write(bw, binary.LittleEndian, sum); err != nil {
+		return cw.n, err
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// encodeItems writes the items section of the encoding to w. Segments of encSegment items are encoded on separate
+// goroutines, at most GOMAXPROCS at a time, and written in order; the bytes are the same as if encoded in one go.
+func encodeItems(w io.Writer, items []item) error {
+	procs := runtime.GOMAXPROCS(0)
+	nseg := (len(items) + encSegment - 1) / encSegment
+	if procs == 1 || nseg < 2 {
+		for len(items) > 0 {
+			n := len(items)
+			if n > encBlock {
+				n = encBlock
+			}
+			if err := binary.Write(w, binary.LittleEndian, items[:n]); err != nil {
+				return err
+			}
+			items = items[n:]
+		}
+		return nil
+	}
+
+	segs := make([]chan []byte, nseg)
+	for i := range segs {
+		segs[i] = make(chan []byte, 1)
+	}
+	sem := make(chan struct{}, procs) // bounds the number of encoded segments waiting to be written.
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		for i, ch := range segs {
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			seg := items[i*encSegment:]
+			if len(seg) > encSegment {
+				seg = seg[:encSegment]
+			}
+			go func(ch chan<- []byte, seg []item) {
+				var buf bytes.Buffer
+				buf.Grow(len(seg) * binary.Size(item{}))
+				binary.Write(&buf, binary.LittleEndian, seg) // writing to a bytes.Buffer cannot fail.
+				ch <- buf.Bytes()
+			}(ch, seg)
+		}
+	}()
+
+	for _, ch := range segs {
+		b := <-ch
+		<-sem
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingReader keeps track of the number of bytes read, for io.ReaderFrom.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// decode reads and validates an encoding from r, returning its items and metadata (nil for version 2 encodings, which have none).
+func decode(r io.Reader) (items []item, meta *Meta, n int64, err error) {
+	meta, n, err = scan(r, func(hr io.Reader, count uint64) error {
+		for count > 0 {
+			n := uint64(encBlock)
+			if n > count {
+				n = count
+			}
+			items = append(items, make([]item, n)...)
+			if err := binary.Read(hr, binary.LittleEndian, items[len(items)-int(n):]); err != nil {
+				return err
+			}
+			count -= n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, meta, n, err
+	}
+	return items, meta, n, nil
+}
+
+// scan reads and validates an encoding from r, returning its metadata as decode does. The items section is consumed by readItems, which is given
+// the reader to read count items from; everything read from it is checksummed.
+func scan(r io.Reader, readItems func(hr io.Reader, count uint64) error) (meta *Meta, n int64, err error) {
+	// not buffered: we must not consume anything past the end of the encoding.
+	cr := &countingReader{r: r}
+	crc := crc32.New(crcTable)
+	hr := io.TeeReader(cr, crc)
+
+	var h encHeader
+	if err := binary.Read(hr, binary.LittleEndian, &h); err != nil {
+		return meta, cr.n, err
+	}
+	if err := h.check(); err != nil {
+		return meta, cr.n, err
+	}
+	if h.Version >= 3 {
+		m, err := readMeta(hr)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, cr.n, err
+		}
+		meta = &m
+	}
+
+	if err := readItems(hr, h.Count); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return meta, cr.n, err
+	}
+
+	var sum uint32
+	if err := binary.Read(cr, binary.LittleEndian, &sum); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return meta, cr.n, err
+	}
+	if sum != crc.Sum32() {
+		return meta, cr.n, &FormatError{"checksum", uint64(sum), uint64(crc.Sum32())}
+	}
+
+	return meta, cr.n, nil
+}
+
+// VerifySnapshot reads an encoding written by WriteTo from r and checks its integrity (header and checksum)
+// without building a hash map, returning the number of items in it. This is meant for auditing stored snapshots.
+// The items are checksummed as they stream by, so memory use does not depend on the size of the snapshot.
+func VerifySnapshot(r io.Reader) (count int, err error) {
+	_, _, err = scan(r, func(hr io.Reader, n uint64) error {
+		size := int64(binary.Size(item{}))
+		copied, err := io.CopyN(ioutil.Discard, hr, int64(n)*size)
+		count = int(copied / size)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ReadFrom reads items written by WriteTo from r and inserts them into c.
+// Items already in c are kept, unless they are replaced by an item with the same key.
+// The metadata is taken over if c is empty; otherwise labels are merged and the higher version is kept (see Meta).
+// The whole input is validated before c is modified; in case of a *FormatError or a read error, c is left untouched.
+// If c cannot grow enough to hold all items, the items which don't fit are dropped as by Insert, and ErrDropped is returned.
+func (c *Cuckoo) ReadFrom(r io.Reader) (int64, error) {
+	items, meta, n, err := decode(r)
+	if err != nil {
+		return n, err
+	}
+
+	fresh := c.nentries == 0
+	if fresh {
+		// make room in advance, rather than growing step by step (each time rehashing everything).
+		δ := LogSizeFor(len(items)) - bshift - c.logsize
+		if c.logsize+δ > hashBits {
+			δ = hashBits - c.logsize
+		}
+		for δ > 0 && !c.canGrow(δ) {
+			δ--
+		}
+		if δ > 0 && c.mayGrow(δ) {
+			c.tryGrow(δ)
+		}
+	}
+
+	nrejected := c.nrejected
+	for _, it := range items {
+		c.Insert(it.Key, it.Value)
+	}
+	if meta != nil {
+		c.setMeta(meta, fresh)
+	}
+
+	if c.nrejected != nrejected {
+		return n, ErrDropped
+	}
+	return n, nil
+}
@@ -0,0 +1,25 @@
+//go:build !cuckoofault
+
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+// Fault injection is compiled in with the cuckoofault build tag only, see fault_on.go.
+// Otherwise these are constant false, and the compiler removes the checks.
+
+func faultWalk() bool    { return false }
+func faultStash() bool   { return false }
+func faultCorrupt() bool { return false }
@@ -0,0 +1,50 @@
+//go:build cuckoofault
+
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"math/rand"
+)
+
+// Faults holds the probabilities (between 0 and 1) of injected failures, for testing how applications cope with them.
+// Fault injection is available only when building with the cuckoofault tag (go test -tags cuckoofault).
+type Faults struct {
+	// Walk is the probability that the random walk of an insertion gives up right away, as if it had hit its step limit.
+	// Combined with Stash, this makes insertions fail, hence the table grow or, at its size limit, items get rejected.
+	Walk float64
+	// Stash is the probability that a free stash cell is taken as occupied.
+	Stash float64
+	// Corrupt is the probability that WriteTo writes a wrong checksum, so that ReadFrom rejects the encoding.
+	Corrupt float64
+}
+
+var faults Faults
+
+// SetFaults sets the probabilities of injected failures. It must not be called while hash maps are in use.
+// Walk and Stash should stay below 1, since grows also insert items and would never succeed.
+func SetFaults(f Faults) {
+	faults = f
+}
+
+func inject(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+func faultWalk() bool    { return inject(faults.Walk) }
+func faultStash() bool   { return inject(faults.Stash) }
+func faultCorrupt() bool { return inject(faults.Corrupt) }
@@ -0,0 +1,103 @@
+//go:build cuckoofault
+
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFaults(t *testing.T) {
+	defer SetFaults(Faults{})
+
+	SetFaults(Faults{Walk: 0.5, Stash: 0.5})
+	c := NewCuckoo(DefaultLogSize)
+	c.SetMaxLogSize(DefaultLogSize)
+	for i := 1; i <= 1<<DefaultLogSize; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+	if c.Rejected() == 0 {
+		t.Error("no insertion failed")
+	}
+
+	SetFaults(Faults{Corrupt: 1})
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCuckoo(DefaultLogSize).ReadFrom(&buf); !errors.Is(err, ErrFormat) {
+		t.Error("got: ", err, " expected: ", ErrFormat)
+	}
+}
+
+func TestTxRollbackFaults(t *testing.T) {
+	defer SetFaults(Faults{})
+
+	nfailed := 0
+	for round := 0; round < 20; round++ {
+		c := NewCuckoo(DefaultLogSize)
+		c.SetMaxLogSize(DefaultLogSize)
+		n := 0
+		for c.Rejected() == 0 {
+			n++
+			c.Insert(Key(n), Value(n))
+		}
+		n--
+		c.nrejected = 0
+		dropped := make(map[Key]bool)
+		c.SetEvictHandler(func(k Key, v Value) { dropped[k] = true })
+		// from the first failed insertion on, the table cannot take items which don't find a free cell right away.
+		c.SetGrowHandler(func(oldCap, newCap int) bool {
+			SetFaults(Faults{Walk: 1, Stash: 1})
+			return false
+		})
+
+		tx := c.Begin()
+		for i := 1; i <= 100; i++ {
+			tx.Delete(Key(i))
+		}
+		for i := 10000; i < 10000+200; i++ {
+			tx.Insert(Key(i), Value(i))
+		}
+		err := tx.Commit()
+		SetFaults(Faults{})
+
+		switch err {
+		case ErrTxFull:
+			if len(dropped) != 0 {
+				t.Fatal("items dropped without ErrTxRollback: ", len(dropped))
+			}
+		case ErrTxRollback:
+			nfailed++
+		default:
+			t.Fatal("got: ", err, " expected: ", ErrTxFull, " or ", ErrTxRollback)
+		}
+		if len(dropped) != c.Rejected() || c.Len()+len(dropped) != n {
+			t.Fatal("got: ", c.Len(), len(dropped), c.Rejected(), " expected: ", n-len(dropped), c.Rejected(), len(dropped))
+		}
+		for i := 1; i <= n; i++ {
+			if _, ok := c.Search(Key(i)); ok == dropped[Key(i)] {
+				t.Fatal("lost key: ", i)
+			}
+		}
+	}
+	if nfailed == 0 {
+		t.Error("no rollback failed")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"time"
+)
+
+// tick samples the growth rate every rateSample insertions and deletions.
+func (c *Cuckoo) tick() {
+	c.nticks++
+	if c.nticks < rateSample {
+		return
+	}
+	c.nticks = 0
+
+	now := time.Now()
+	if !c.rateTime.IsZero() {
+		if dt := now.Sub(c.rateTime).Seconds(); dt > 0 {
+			r := float64(c.nentries-c.rateLen) / dt
+			c.rate += rateSmoothing * (r - c.rate)
+		}
+	}
+	c.rateTime, c.rateLen = now, c.nentries
+}
+
+// GrowthRate returns a moving average of the net number of items added per second, which is negative if the hash map is
+// shrinking. It is sampled every rateSample (see config.go) insertions and deletions, so it is 0 until enough of them took place.
+func (c *Cuckoo) GrowthRate() float64 {
+	return c.rate
+}
+
+// ForecastFullIn estimates how long it will take, at the current GrowthRate, until the hash map is full:
+// its load factor reaches rehashThreshold (see config.go) at its size limit (see SetMaxLogSize) or,
+// if there is no limit, at its current size, which is when it would grow.
+// It returns 0 if the hash map is already full, and a negative duration if the hash map is not growing.
+func (c *Cuckoo) ForecastFullIn() time.Duration {
+	capacity := c.Cap()
+	if c.maxLogsize != 0 {
+		capacity = 1 << uint(c.maxLogsize+bshift)
+	}
+
+	remaining := rehashThreshold*float64(capacity) - float64(c.nentries)
+	switch {
+	case remaining <= 0:
+		return 0
+	case c.rate <= 0:
+		return -1
+	}
+	return time.Duration(remaining / c.rate * float64(time.Second))
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"sync"
+)
+
+// Group answers lookups over several hash maps, such as one per day or per shard in a time-partitioned store.
+// Members are consulted in order, and the first one holding the key wins.
+// Similar to Cuckoo, a Group is not thread-safe, even though SearchParallel queries members in parallel.
+type Group struct {
+	members []Map
+	hits    []int // number of lookups answered by each member.
+	misses  int   // number of lookups no member could answer.
+}
+
+// NewGroup returns a Group over members, which are consulted in the given order.
+func NewGroup(members ...Map) *Group {
+	return &Group{
+		members: members,
+		hits:    make([]int, len(members)),
+	}
+}
+
+// Members returns the members of the group.
+func (g *Group) Members() []Map {
+	return g.members
+}
+
+// Search looks k up in the members in order, stopping at the first one holding it.
+// It returns the value found and the index of that member, or -1 if none holds k.
+func (g *Group) Search(k Key) (v Value, member int, ok bool) {
+	for i, m := range g.members {
+		if v, ok = m.Search(k); ok {
+			g.hits[i]++
+			return v, i, true
+		}
+	}
+	g.misses++
+	return zero, -1, false
+}
+
+// SearchParallel is like Search, but queries all members at once, each in its own goroutine.
+// It pays off only for members which are slow to query; the members must tolerate concurrent reads, which Cuckoo does.
+func (g *Group) SearchParallel(k Key) (v Value, member int, ok bool) {
+	type result struct {
+		v  Value
+		ok bool
+	}
+	results := make([]result, len(g.members))
+
+	var wg sync.WaitGroup
+	wg.Add(len(g.members))
+	for i, m := range g.members {
+		go func(i int, m Map) {
+			defer wg.Done()
+			results[i].v, results[i].ok = m.Search(k)
+		}(i, m)
+	}
+	wg.Wait()
+
+	for i, r := range results {
+		if r.ok {
+			g.hits[i]++
+			return r.v, i, true
+		}
+	}
+	g.misses++
+	return zero, -1, false
+}
+
+// Hits returns the number of lookups answered by each member, and the number of lookups none could answer.
+func (g *Group) Hits() (hits []int, misses int) {
+	return append([]int(nil), g.hits...), g.misses
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package hashsanity measures the quality of seeded 32-bit hash functions of the kind used by package cuckoo,
+// so that a custom hash function can be checked before it is trusted with a real key set.
+//
+// Analyze reports how uniformly a key set is spread over buckets, how well single-bit changes in the key
+// avalanche into the output, and how independent the bucket choices under two different seeds are;
+// the last one matters for cuckoo hashing, where the candidate buckets of a key come from the same function with different seeds.
+package hashsanity
+
+import (
+	"fmt"
+	"math"
+)
+
+// Func is a seeded hash function of a 32-bit key.
+type Func func(k, seed uint32) uint32
+
+// avalancheKeys is the maximum number of keys used for the avalanche test, which costs 33 hash calls per key.
+const avalancheKeys = 1 << 12
+
+// seeds used throughout the analysis.
+const (
+	seed0 = 0x9e3779b9
+	seed1 = 0x7f4a7c15
+)
+
+// Report holds the results of Analyze.
+type Report struct {
+	Keys    int // number of keys analyzed.
+	Buckets int // number of buckets the keys were distributed into.
+
+	// ChiSquare is the chi-square statistic of the bucket loads. For a uniform hash function it is close to Buckets-1;
+	// ChiSquareZ is the same value normalized to a standard score, so anything beyond a few units is suspicious.
+	ChiSquare  float64
+	ChiSquareZ float64
+	MaxLoad    int     // load of the fullest bucket.
+	MeanLoad   float64 // Keys/Buckets.
+
+	// Avalanche is the mean probability that an output bit flips when a single input bit is flipped, ideally 0.5.
+	// AvalancheBias is the largest deviation from 0.5 over all (input bit, output bit) pairs, ideally close to 0.
+	Avalanche     float64
+	AvalancheBias float64
+
+	Collisions int // number of keys whose 32-bit hash equals the hash of a preceding key.
+
+	// SeedCorrelation is the fraction of keys that land in the same bucket under two different seeds, ideally 1/Buckets.
+	SeedCorrelation float64
+}
+
+// Analyze runs f over keys (which should not contain duplicates) with 2^logsize buckets, and reports on its quality.
+func Analyze(f Func, keys []uint32, logsize int) Report {
+	r := Report{
+		Keys:    len(keys),
+		Buckets: 1 << uint(logsize),
+	}
+	if len(keys) == 0 {
+		return r
+	}
+
+	mask := uint32(r.Buckets - 1)
+	loads := make([]int, r.Buckets)
+	hashes := make(map[uint32]struct{}, len(keys))
+	same := 0
+
+	for _, k := range keys {
+		h := f(k, seed0)
+		if _, ok := hashes[h]; ok {
+			r.Collisions++
+		}
+		hashes[h] = struct{}{}
+
+		loads[h&mask]++
+		if h&mask == f(k, seed1)&mask {
+			same++
+		}
+	}
+
+	r.MeanLoad = float64(len(keys)) / float64(r.Buckets)
+	for _, l := range loads {
+		d := float64(l) - r.MeanLoad
+		r.ChiSquare += d * d / r.MeanLoad
+		if l > r.MaxLoad {
+			r.MaxLoad = l
+		}
+	}
+	df := float64(r.Buckets - 1)
+	if df > 0 {
+		r.ChiSquareZ = (r.ChiSquare - df) / math.Sqrt(2*df)
+	}
+	r.SeedCorrelation = float64(same) / float64(len(keys))
+
+	r.Avalanche, r.AvalancheBias = avalanche(f, keys)
+
+	return r
+}
+
+func avalanche(f Func, keys []uint32) (mean, bias float64) {
+	if len(keys) > avalancheKeys {
+		keys = keys[:avalancheKeys]
+	}
+
+	var flips [32][32]int
+	for _, k := range keys {
+		h := f(k, seed0)
+		for i := uint(0); i < 32; i++ {
+			d := h ^ f(k^1<<i, seed0)
+			for j := uint(0); j < 32; j++ {
+				flips[i][j] += int(d >> j & 1)
+			}
+		}
+	}
+
+	n := float64(len(keys))
+	total := 0
+	for i := range flips {
+		for j := range flips[i] {
+			total += flips[i][j]
+			if b := math.Abs(float64(flips[i][j])/n - 0.5); b > bias {
+				bias = b
+			}
+		}
+	}
+	mean = float64(total) / (n * 32 * 32)
+
+	return
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("keys: %d buckets: %d\n"+
+		"bucket loads: mean %.2f max %d, chi-square %.1f (z=%.2f)\n"+
+		"avalanche: mean %.4f, worst bias %.4f\n"+
+		"collisions: %d\n"+
+		"seed correlation: %.6f (ideal %.6f)",
+		r.Keys, r.Buckets,
+		r.MeanLoad, r.MaxLoad, r.ChiSquare, r.ChiSquareZ,
+		r.Avalanche, r.AvalancheBias,
+		r.Collisions,
+		r.SeedCorrelation, 1/float64(r.Buckets))
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package hashsanity
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// murmur3 finalizer, mixed with the seed.
+func fmix(k, seed uint32) uint32 {
+	h := k ^ seed
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// ignores its seed, and barely mixes.
+func weak(k, seed uint32) uint32 {
+	return k * 2654435761
+}
+
+func TestAnalyze(t *testing.T) {
+	keys := make([]uint32, 1<<16)
+	for i := range keys {
+		keys[i] = rand.Uint32()
+	}
+
+	good := Analyze(fmix, keys, 10)
+	t.Log("fmix:\n", good)
+	if good.AvalancheBias > 0.05 || good.SeedCorrelation > 0.01 || good.ChiSquareZ > 5 {
+		t.Error("good hash function flagged")
+	}
+
+	bad := Analyze(weak, keys, 10)
+	t.Log("weak:\n", bad)
+	if bad.AvalancheBias < 0.4 || bad.SeedCorrelation != 1 {
+		t.Error("weak hash function not flagged")
+	}
+}
@@ -0,0 +1,82 @@
+//go:build go1.23
+
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"iter"
+)
+
+// BucketView is a read-only view of a bucket (or of the stash), see Buckets.
+// Keys and Values alias the table and are only valid until the next modification of the hash map.
+// Empty cells have key 0.
+type BucketView struct {
+	Used   int // number of occupied cells.
+	Keys   []Key
+	Values []Value
+}
+
+// All returns an iterator over all (key, value) pairs in the hash map, like ForRange.
+// The hash map must not be modified during the iteration.
+func (c *Cuckoo) All() iter.Seq2[Key, Value] {
+	return func(yield func(Key, Value) bool) {
+		if c.zeroIsSet && !yield(0, c.zeroValue) {
+			return
+		}
+
+		for bi := range c.buckets {
+			b := &c.buckets[bi]
+			for i, key := range &b.keys {
+				if key != 0 && !yield(key, b.vals[i]) {
+					return
+				}
+			}
+		}
+
+		for i, key := range c.stash.keys {
+			if key != 0 && !yield(key, c.stash.vals[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Buckets returns an iterator over the buckets of the table, in order, followed by the stash with index -1.
+// The item with key 0 is kept aside and is not part of any bucket.
+// The hash map must not be modified during the iteration.
+func (c *Cuckoo) Buckets() iter.Seq2[int, BucketView] {
+	return func(yield func(int, BucketView) bool) {
+		for bi := range c.buckets {
+			b := &c.buckets[bi]
+			if !yield(bi, view(b.keys[:], b.vals[:])) {
+				return
+			}
+		}
+
+		yield(-1, view(c.stash.keys[:], c.stash.vals[:]))
+	}
+}
+
+func view(keys []Key, vals []Value) BucketView {
+	v := BucketView{Keys: keys, Values: vals}
+	for _, key := range keys {
+		if key != 0 {
+			v.Used++
+		}
+	}
+	return v
+}
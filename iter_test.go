@@ -0,0 +1,62 @@
+//go:build go1.23
+
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"testing"
+)
+
+func TestIterators(t *testing.T) {
+	c := NewCuckoo(0)
+	for i := 0; i < 1000; i++ {
+		c.Insert(Key(i), Value(i))
+	}
+
+	n := 0
+	for k, v := range c.All() {
+		if v != Value(k) {
+			t.Error("got: ", v, " expected: ", k)
+		}
+		n++
+	}
+	if n != c.Len() {
+		t.Error("got: ", n, " expected: ", c.Len())
+	}
+
+	n = 0
+	for range c.All() {
+		n++
+		if n == 10 {
+			break
+		}
+	}
+
+	used, stashed := 0, 0
+	for bi, b := range c.Buckets() {
+		used += b.Used
+		if bi < 0 {
+			stashed = b.Used
+		}
+	}
+	if used != c.Len()-1 { // key 0 is not in any bucket.
+		t.Error("got: ", used, " expected: ", c.Len()-1)
+	}
+	if stashed > stashSize {
+		t.Error("got: ", stashed, " stashed items")
+	}
+}
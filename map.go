@@ -0,0 +1,41 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+// Map is the set of operations common to the hash map implementations of this package, Cuckoo and Concurrent.
+// Code written against Map can switch between them by changing the constructor only.
+type Map interface {
+	Search(k Key) (v Value, ok bool)
+	Insert(k Key, v Value)
+	Delete(k Key)
+	Len() int
+	ForRange(f func(Key, Value))
+}
+
+var (
+	_ Map = (*Cuckoo)(nil)
+	_ Map = (*Concurrent)(nil)
+)
+
+// CountBy returns the number of items of m in each class, as told by classify; for instance, classify can extract a tenant
+// from the key to find out which tenant fills a shared hash map. Only the classes of at least one item are present in the result.
+func CountBy(m Map, classify func(Key, Value) string) map[string]int {
+	counts := make(map[string]int)
+	m.ForRange(func(k Key, v Value) {
+		counts[classify(k, v)]++
+	})
+	return counts
+}
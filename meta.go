@@ -0,0 +1,164 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// MaxLabelSize bounds the length of label keys and values, so that a corrupted length cannot exhaust memory when decoding.
+const MaxLabelSize = 1 << 16
+
+// ErrLabelSize is returned by SetLabel for a key or value longer than MaxLabelSize.
+var ErrLabelSize = errors.New("cuckoo: label too long")
+
+// Meta holds metadata of a hash map, which is preserved by WriteTo and ReadFrom.
+type Meta struct {
+	Created time.Time         // when the hash map was created.
+	Version uint64            // incremented on every modification, so the higher version of two copies is the more recent one.
+	Labels  map[string]string // free-form labels, such as what the hash map was built from; see SetLabel.
+}
+
+// Meta returns the metadata of c.
+func (c *Cuckoo) Meta() Meta {
+	m := Meta{
+		Created: c.created,
+		Version: c.version,
+		Labels:  make(map[string]string, len(c.labels)),
+	}
+	for k, v := range c.labels {
+		m.Labels[k] = v
+	}
+	return m
+}
+
+// SetLabel sets the label key to value; an empty value removes the label.
+// Keys and values longer than MaxLabelSize bytes are rejected with ErrLabelSize, since they could not be read back.
+func (c *Cuckoo) SetLabel(key, value string) error {
+	if len(key) > MaxLabelSize || len(value) > MaxLabelSize {
+		return ErrLabelSize
+	}
+	if value == "" {
+		delete(c.labels, key)
+		return nil
+	}
+	if c.labels == nil {
+		c.labels = make(map[string]string)
+	}
+	c.labels[key] = value
+	return nil
+}
+
+// setMeta merges m, read by ReadFrom, into the metadata of c. fresh tells whether c was empty before.
+func (c *Cuckoo) setMeta(m *Meta, fresh bool) {
+	if fresh {
+		if !m.Created.IsZero() { // unknown, see WriteContentTo.
+			c.created = m.Created
+		}
+		c.version = m.Version
+	} else if m.Version > c.version {
+		c.version = m.Version
+	}
+	for k, v := range m.Labels {
+		c.SetLabel(k, v)
+	}
+}
+
+// writeMeta writes the metadata section of the encoding, see encoding.go. Labels are sorted to keep the encoding deterministic.
+func writeMeta(w io.Writer, m *Meta) error {
+	created := int64(0)
+	if !m.Created.IsZero() {
+		created = m.Created.UnixNano()
+	}
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := make([]byte, 0, 20)
+	b = appendUint64(b, uint64(created))
+	b = appendUint64(b, m.Version)
+	b = appendUint32(b, uint32(len(keys)))
+	for _, k := range keys {
+		b = appendUint32(b, uint32(len(k)))
+		b = append(b, k...)
+		b = appendUint32(b, uint32(len(m.Labels[k])))
+		b = append(b, m.Labels[k]...)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func appendUint32(b []byte, x uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, x uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+// readMeta reads the metadata section written by writeMeta.
+func readMeta(r io.Reader) (m Meta, err error) {
+	var fixed struct {
+		Created int64
+		Version uint64
+		NLabels uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &fixed); err != nil {
+		return m, err
+	}
+	if fixed.Created != 0 {
+		m.Created = time.Unix(0, fixed.Created)
+	}
+	m.Version = fixed.Version
+
+	readString := func() (string, error) {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", err
+		}
+		if n > MaxLabelSize {
+			return "", &FormatError{"label size", uint64(n), MaxLabelSize}
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return string(b), err
+	}
+
+	m.Labels = make(map[string]string)
+	for i := uint32(0); i < fixed.NLabels; i++ {
+		k, err := readString()
+		if err != nil {
+			return m, err
+		}
+		v, err := readString()
+		if err != nil {
+			return m, err
+		}
+		m.Labels[k] = v
+	}
+	return m, nil
+}
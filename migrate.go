@@ -0,0 +1,58 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"context"
+)
+
+// MigrateTo copies the items of c into dst, batch items at a time, for moving to a differently sized or configured hash map.
+// After every batch, progress (if non-nil) is called with the number of items copied so far, and ctx is checked:
+// if it is done, MigrateTo stops and returns ctx.Err(). progress can throttle the migration by blocking.
+// c must not be modified during the migration; dst may be a Concurrent in use by other goroutines.
+func (c *Cuckoo) MigrateTo(ctx context.Context, dst Map, batch int, progress func(done int)) error {
+	if batch <= 0 {
+		batch = encBlock
+	}
+
+	var err error
+	done, pending := 0, 0
+	c.ForRange(func(k Key, v Value) {
+		if err != nil {
+			return
+		}
+
+		dst.Insert(k, v)
+		done++
+		if pending++; pending < batch {
+			return
+		}
+		pending = 0
+
+		if progress != nil {
+			progress(done)
+		}
+		err = ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	if pending > 0 && progress != nil {
+		progress(done)
+	}
+	return ctx.Err()
+}
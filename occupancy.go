@@ -0,0 +1,48 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// WriteOccupancyPNG renders the fill level of every bucket as a PNG heatmap, one pixel per bucket in row-major order,
+// width pixels per row. Empty buckets are blue, full buckets are red. Stripes or blotches in the picture hint at
+// clustering caused by a poor hash function.
+func (c *Cuckoo) WriteOccupancyPNG(w io.Writer, width int) error {
+	if width <= 0 {
+		width = 1
+	}
+	height := (len(c.buckets) + width - 1) / width
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for bi := range c.buckets {
+		used := 0
+		for _, key := range &c.buckets[bi].keys {
+			if key != 0 {
+				used++
+			}
+		}
+
+		heat := uint8(used * 255 / blen)
+		img.SetRGBA(bi%width, bi/width, color.RGBA{R: heat, B: 255 - heat, A: 255})
+	}
+
+	return png.Encode(w, img)
+}
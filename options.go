@@ -0,0 +1,95 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"fmt"
+)
+
+// Config holds the run-time parameters of a hash map as a plain struct, so they can be kept in configuration files.
+// Compile-time parameters (bucket size, number of hash functions, stash size, ...) live in config.go instead.
+type Config struct {
+	LogSize    int       `json:"logSize"`              // initial log size, as in NewCuckoo; 0 means DefaultLogSize.
+	MaxLogSize int       `json:"maxLogSize,omitempty"` // size limit, as in SetMaxLogSize; 0 means none.
+	Admission  Admission `json:"admission,omitempty"`  // what to do when full at the size limit, as in SetAdmission.
+}
+
+// Validate reports whether the parameters in cfg are usable.
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.LogSize < 0 || cfg.LogSize > hashBits+bshift:
+		return fmt.Errorf("cuckoo: log size %d out of range [0, %d]", cfg.LogSize, hashBits+bshift)
+	case cfg.MaxLogSize < 0 || cfg.MaxLogSize > hashBits+bshift:
+		return fmt.Errorf("cuckoo: max log size %d out of range [0, %d]", cfg.MaxLogSize, hashBits+bshift)
+	case cfg.MaxLogSize != 0 && cfg.MaxLogSize < cfg.logSize():
+		return fmt.Errorf("cuckoo: max log size %d is below log size %d", cfg.MaxLogSize, cfg.logSize())
+	case cfg.Admission != RejectNew && cfg.Admission != EvictRandom:
+		return fmt.Errorf("cuckoo: unknown admission policy %d", cfg.Admission)
+	}
+	return nil
+}
+
+func (cfg *Config) logSize() int {
+	if cfg.LogSize == 0 {
+		return DefaultLogSize
+	}
+	return cfg.LogSize
+}
+
+// NewFromConfig creates a new hash map with the parameters in cfg, after validating them.
+func NewFromConfig(cfg Config) (*Cuckoo, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := NewCuckoo(cfg.logSize())
+	c.SetMaxLogSize(cfg.MaxLogSize)
+	c.SetAdmission(cfg.Admission)
+	return c, nil
+}
+
+// Params holds the effective parameters of a hash map, for logging or persisting them.
+type Params struct {
+	LogSize    int       // current log size, in the units of NewCuckoo.
+	MaxLogSize int       // size limit, as in SetMaxLogSize; 0 means none.
+	Admission  Admission // see SetAdmission.
+	Seeds      [NumHash]uint32
+	CustomHash bool // whether a hash function was set with SetHash.
+
+	// compile-time parameters, see config.go.
+	BucketSize int
+	NumHash    int
+	StashSize  int
+}
+
+// Params returns the effective parameters of c. Seeds change whenever the table grows or is rehashed.
+func (c *Cuckoo) Params() Params {
+	p := Params{
+		LogSize:    c.logsize + bshift,
+		Admission:  c.admission,
+		CustomHash: c.hashfn != nil,
+		BucketSize: blen,
+		NumHash:    nhash,
+		StashSize:  stashSize,
+	}
+	if c.maxLogsize != 0 {
+		p.MaxLogSize = c.maxLogsize + bshift
+	}
+	for i, seed := range &c.seed {
+		p.Seeds[i] = uint32(seed)
+	}
+	return p
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+// Overflow is a size-limited hash map which never drops items: those which do not fit into the primary table
+// spill over into a secondary table, which is much smaller initially but has no size limit.
+// Similar to Cuckoo, an Overflow is not thread-safe.
+type Overflow struct {
+	primary, secondary *Cuckoo
+	nspilled           int
+	onSpill            func(Key, Value)
+}
+
+var _ Map = (*Overflow)(nil)
+
+// NewOverflow creates an Overflow whose primary table starts at logsize and never grows beyond maxLogsize
+// (both as in NewCuckoo and SetMaxLogSize).
+func NewOverflow(logsize, maxLogsize int) *Overflow {
+	o := &Overflow{
+		primary:   NewCuckoo(logsize),
+		secondary: NewCuckoo(maxLogsize - overflowShift),
+	}
+	o.primary.SetMaxLogSize(maxLogsize)
+	o.primary.SetEvictHandler(o.spill)
+	return o
+}
+
+func (o *Overflow) spill(k Key, v Value) {
+	o.nspilled++
+	o.secondary.Insert(k, v)
+	if o.onSpill != nil {
+		o.onSpill(k, v)
+	}
+}
+
+// SetSpillHandler registers f to be called with every item spilling over into the secondary table, for alerting.
+// f must not modify the hash map. Passing nil removes the handler.
+func (o *Overflow) SetSpillHandler(f func(Key, Value)) {
+	o.onSpill = f
+}
+
+// Search tries to retrieve the value associated with the given key.
+// If no such item is found, ok is set to false.
+func (o *Overflow) Search(k Key) (v Value, ok bool) {
+	if v, ok = o.primary.Search(k); ok || o.secondary.nentries == 0 {
+		return
+	}
+	return o.secondary.Search(k)
+}
+
+// Insert adds given key/value item into the hash map.
+// If an item with key k already exists, it will be replaced.
+func (o *Overflow) Insert(k Key, v Value) {
+	if o.secondary.nentries > 0 {
+		if p := o.secondary.lookup(k); p != nil {
+			*p = v
+			return
+		}
+	}
+	o.primary.Insert(k, v)
+}
+
+// Delete removes the item corresponding to the given key (if exists).
+func (o *Overflow) Delete(k Key) {
+	o.primary.Delete(k)
+	if o.secondary.nentries > 0 {
+		o.secondary.Delete(k)
+	}
+}
+
+// Len returns the number of items in the hash map.
+func (o *Overflow) Len() int {
+	return o.primary.nentries + o.secondary.nentries
+}
+
+// ForRange loops over all (key,value) pairs in the hash map and calls f for each.
+func (o *Overflow) ForRange(f func(Key, Value)) {
+	o.primary.ForRange(f)
+	o.secondary.ForRange(f)
+}
+
+// Spilled returns the number of items which spilled over into the secondary table so far,
+// and the number of items currently there.
+func (o *Overflow) Spilled() (total, current int) {
+	return o.nspilled, o.secondary.nentries
+}
+
+// Stats returns the statistics of the primary and the secondary table.
+func (o *Overflow) Stats() (primary, secondary Stats) {
+	return o.primary.Stats(), o.secondary.Stats()
+}
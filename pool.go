@@ -0,0 +1,56 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"sync"
+	"time"
+)
+
+// Pool recycles hash maps, for programs which create and drop many short-lived ones.
+// Hash maps are kept in size classes (by log size), so a map from Get reuses the buckets of a dropped map of the same size.
+// Unlike Cuckoo, a Pool is safe for concurrent use. The zero Pool is ready to use.
+type Pool struct {
+	classes [hashBits + 1]sync.Pool
+}
+
+// Get returns an empty hash map with the given initial log size (as in NewCuckoo), reusing a map given to Put if possible.
+func (p *Pool) Get(logsize int) *Cuckoo {
+	l := logsize - bshift
+	if l <= 0 {
+		l = 1
+	}
+	if l > hashBits {
+		panic("cuckoo: log size is too large")
+	}
+
+	if c, ok := p.classes[l].Get().(*Cuckoo); ok {
+		c.reseed()
+		c.created = time.Now()
+		return c
+	}
+	return NewCuckoo(logsize)
+}
+
+// Put empties c and returns it to the pool; c must not be used afterwards.
+// The settings and metadata of c (size limit, handlers, alarms, labels) are dropped, so a map from Get starts out like one from NewCuckoo.
+func (p *Pool) Put(c *Cuckoo) {
+	for i := range c.buckets {
+		c.buckets[i] = bucket{}
+	}
+	*c = Cuckoo{buckets: c.buckets, logsize: c.logsize}
+	p.classes[c.logsize].Put(c)
+}
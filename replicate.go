@@ -0,0 +1,203 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Replication stream: an encoding of the whole hash map (see WriteTo), followed by a log of operations, each framed as
+//
+//	size   uint32   number of bytes that follow in this frame
+//	op     uint8    opInsert or opDelete
+//	key    Key
+//	value  Value    opInsert only
+//
+// little-endian as in the encoding. Follow skips frames with an unknown op, so that new operations can be added
+// without breaking older standbys.
+const (
+	opInsert  = 'I'
+	opDelete  = 'D'
+	maxOpSize = 1 << 16 // bound on the size of a frame, so that a corrupted size cannot exhaust memory.
+)
+
+var (
+	opKeySize = binary.Size(Key(0))
+	opValSize = binary.Size(zero)
+)
+
+// Primary is a hash map whose modifications are streamed to a standby (see Follow), which thereby keeps a copy of it.
+// Operations are buffered; call Flush to bound how far the standby lags behind.
+// Similar to Cuckoo, a Primary is not thread-safe.
+type Primary struct {
+	c     *Cuckoo
+	w     *bufio.Writer
+	frame bytes.Buffer // reused for encoding each operation.
+	err   error
+}
+
+var _ Map = (*Primary)(nil)
+
+// NewPrimary starts replicating c to w, by sending an encoding of c right away. c must not be modified
+// other than through the returned Primary afterwards.
+// If c has a size limit (see SetMaxLogSize), items dropped by Insert are logged as deletions, so that the standby
+// drops them as well. For this, NewPrimary wraps the evict handler of c, which must not be replaced afterwards.
+func NewPrimary(c *Cuckoo, w io.Writer) (*Primary, error) {
+	p := &Primary{c: c, w: bufio.NewWriter(w)}
+	if _, err := c.WriteTo(p.w); err != nil {
+		return nil, err
+	}
+
+	onEvict := c.onEvict
+	c.SetEvictHandler(func(k Key, v Value) {
+		p.log(opDelete, k, nil)
+		if onEvict != nil {
+			onEvict(k, v)
+		}
+	})
+	return p, p.Flush()
+}
+
+// log writes a frame for op on k, with the value v unless it is nil.
+func (p *Primary) log(op byte, k Key, v *Value) {
+	if p.err != nil {
+		return
+	}
+
+	var b [8]byte
+	p.frame.Reset()
+	p.frame.Write(b[:4]) // size, filled in below.
+	p.frame.WriteByte(op)
+	binary.LittleEndian.PutUint64(b[:], uint64(k))
+	p.frame.Write(b[:opKeySize])
+	if v != nil {
+		// Value may be any fixed-size type, so leave it to encoding/binary; writing to a bytes.Buffer cannot fail.
+		binary.Write(&p.frame, binary.LittleEndian, v)
+	}
+
+	f := p.frame.Bytes()
+	binary.LittleEndian.PutUint32(f, uint32(len(f)-4))
+	_, p.err = p.w.Write(f)
+}
+
+// Err returns the first error met while writing to the standby. Once it is set, replication has stopped,
+// while the hash map itself keeps working.
+func (p *Primary) Err() error {
+	return p.err
+}
+
+// Flush sends buffered operations to the standby.
+func (p *Primary) Flush() error {
+	if p.err == nil {
+		p.err = p.w.Flush()
+	}
+	return p.err
+}
+
+// Insert adds given key/value item into the hash map and logs it, unless the item was dropped due to the size limit.
+func (p *Primary) Insert(k Key, v Value) {
+	p.c.Insert(k, v)
+	if _, ok := p.c.Search(k); ok {
+		p.log(opInsert, k, &v)
+	}
+}
+
+// Delete removes the item corresponding to the given key (if exists) and logs it.
+func (p *Primary) Delete(k Key) {
+	p.c.Delete(k)
+	p.log(opDelete, k, nil)
+}
+
+// Search tries to retrieve the value associated with the given key.
+func (p *Primary) Search(k Key) (Value, bool) {
+	return p.c.Search(k)
+}
+
+// Len returns the number of items in the hash map.
+func (p *Primary) Len() int {
+	return p.c.Len()
+}
+
+// ForRange loops over all (key,value) pairs in the hash map and calls f for each.
+func (p *Primary) ForRange(f func(Key, Value)) {
+	p.c.ForRange(f)
+}
+
+// Follow reads a replication stream sent by a Primary from r and applies it to m, which should be empty initially.
+// It returns when the stream ends: nil if it ended cleanly between two operations, and an error otherwise.
+// For a Concurrent m, other goroutines can serve lookups while Follow is running.
+func Follow(r io.Reader, m Map) error {
+	items, _, _, err := decode(r)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		m.Insert(it.Key, it.Value)
+	}
+
+	br := bufio.NewReader(r)
+	var size [4]byte
+	var frame []byte
+	for {
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n == 0 || n > maxOpSize {
+			return fmt.Errorf("cuckoo: invalid replication frame size %d", n)
+		}
+		if uint32(cap(frame)) < n {
+			frame = make([]byte, n)
+		}
+		frame = frame[:n]
+		if _, err := io.ReadFull(br, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		op, body := frame[0], frame[1:]
+		switch {
+		case op == opInsert && len(body) == opKeySize+opValSize:
+			var v Value
+			if err := binary.Read(bytes.NewReader(body[opKeySize:]), binary.LittleEndian, &v); err != nil {
+				return err
+			}
+			m.Insert(opKey(body), v)
+		case op == opDelete && len(body) == opKeySize:
+			m.Delete(opKey(body))
+		case op == opInsert || op == opDelete:
+			return fmt.Errorf("cuckoo: invalid replication frame size %d for opcode %#x", n, op)
+		}
+	}
+}
+
+// opKey decodes the key at the start of b.
+func opKey(b []byte) Key {
+	x := uint64(0)
+	for j := opKeySize - 1; j >= 0; j-- {
+		x = x<<8 | uint64(b[j])
+	}
+	return Key(x)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+// Set is a set of keys with the method names of bloom filter packages (such as github.com/bits-and-blooms/bloom),
+// so that code written against those can switch to a Set by changing its constructor, gaining deletion and exact answers.
+// Since keys are integers here, callers hashing []byte to feed a bloom filter must derive a Key instead.
+// Similar to Cuckoo, a Set is not thread-safe.
+type Set struct {
+	c *Cuckoo
+}
+
+// NewSet creates a set sized for n keys, see LogSizeFor. It grows if more are added.
+func NewSet(n int) *Set {
+	return &Set{c: NewCuckoo(LogSizeFor(n))}
+}
+
+// Add adds k to the set, returning the set for chaining.
+func (s *Set) Add(k Key) *Set {
+	s.c.Insert(k, zero)
+	return s
+}
+
+// Test reports whether k is in the set.
+func (s *Set) Test(k Key) bool {
+	_, ok := s.c.Search(k)
+	return ok
+}
+
+// TestAndAdd reports whether k was in the set, and adds it if not.
+func (s *Set) TestAndAdd(k Key) bool {
+	_, loaded := s.c.GetOrInsert(k, zero)
+	return loaded
+}
+
+// Remove removes k from the set, which bloom filters cannot do.
+func (s *Set) Remove(k Key) *Set {
+	s.c.Delete(k)
+	return s
+}
+
+// ClearAll removes all keys from the set, returning the set for chaining.
+func (s *Set) ClearAll() *Set {
+	s.c.Reset()
+	return s
+}
+
+// Len returns the number of keys in the set.
+func (s *Set) Len() int {
+	return s.c.Len()
+}
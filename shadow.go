@@ -0,0 +1,103 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Divergence describes a Search (or Len) on a Shadow for which the two maps disagreed.
+type Divergence struct {
+	Op         string // "Search" or "Len"
+	Key        Key
+	Old, New   Value
+	OldN, NewN int  // lengths, for Len
+	OldOK      bool // whether Old found Key
+	NewOK      bool // whether New found Key
+}
+
+func (d Divergence) String() string {
+	if d.Op == "Len" {
+		return fmt.Sprintf("Len: old %d, new %d", d.OldN, d.NewN)
+	}
+	return fmt.Sprintf("Search(%v): old (%v, %v), new (%v, %v)", d.Key, d.Old, d.OldOK, d.New, d.NewOK)
+}
+
+// Shadow applies every operation to two maps, for validating a migration from Old to New before the cutover.
+// Results are always taken from Old; whenever New disagrees, OnDiverge is called (if non-nil) and the divergence is counted.
+// Similar to Cuckoo, a Shadow is not thread-safe.
+type Shadow struct {
+	Old, New  Map
+	OnDiverge func(Divergence)
+
+	ndiverged int
+}
+
+var _ Map = (*Shadow)(nil)
+
+// NewShadow returns a Shadow over old and new, calling onDiverge on every disagreement.
+func NewShadow(old, new Map, onDiverge func(Divergence)) *Shadow {
+	return &Shadow{Old: old, New: new, OnDiverge: onDiverge}
+}
+
+// Diverged returns the number of divergences seen so far.
+func (s *Shadow) Diverged() int {
+	return s.ndiverged
+}
+
+func (s *Shadow) diverge(d Divergence) {
+	s.ndiverged++
+	if s.OnDiverge != nil {
+		s.OnDiverge(d)
+	}
+}
+
+// Search looks k up in both maps and returns Old's result.
+func (s *Shadow) Search(k Key) (Value, bool) {
+	v, ok := s.Old.Search(k)
+	nv, nok := s.New.Search(k)
+	if ok != nok || (ok && !reflect.DeepEqual(v, nv)) {
+		s.diverge(Divergence{Op: "Search", Key: k, Old: v, New: nv, OldOK: ok, NewOK: nok})
+	}
+	return v, ok
+}
+
+// Insert inserts k/v into both maps.
+func (s *Shadow) Insert(k Key, v Value) {
+	s.Old.Insert(k, v)
+	s.New.Insert(k, v)
+}
+
+// Delete deletes k from both maps.
+func (s *Shadow) Delete(k Key) {
+	s.Old.Delete(k)
+	s.New.Delete(k)
+}
+
+// Len returns the number of items in Old.
+func (s *Shadow) Len() int {
+	n, nn := s.Old.Len(), s.New.Len()
+	if n != nn {
+		s.diverge(Divergence{Op: "Len", OldN: n, NewN: nn})
+	}
+	return n
+}
+
+// ForRange calls f on the items of Old.
+func (s *Shadow) ForRange(f func(Key, Value)) {
+	s.Old.ForRange(f)
+}
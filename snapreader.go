@@ -0,0 +1,114 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// SnapshotReader answers lookups directly from an encoding written by WriteTo, without building a hash map.
+// Since items are sorted by key in the encoding, a lookup is a binary search taking O(log n) time, and opening costs
+// only a checksum pass over the data. This suits short-lived programs where loading would dominate.
+type SnapshotReader struct {
+	items    []byte // count × (key, value)
+	meta     Meta
+	count    int
+	itemSize int
+	keySize  int
+}
+
+// NewSnapshotReader validates the encoding in b (header and checksum) and returns a reader over it.
+// b must not be modified while the reader is in use; a file can be read whole with ioutil.ReadFile, or memory-mapped.
+func NewSnapshotReader(b []byte) (*SnapshotReader, error) {
+	var h encHeader
+	hsize := binary.Size(h)
+	if len(b) < hsize {
+		return nil, &FormatError{"size", uint64(len(b)), uint64(hsize)}
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+
+	r := &SnapshotReader{
+		keySize:  int(h.KeySize),
+		itemSize: binary.Size(item{}),
+	}
+	if h.Version >= 3 {
+		br := bytes.NewReader(b[hsize:])
+		meta, err := readMeta(br)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = &FormatError{"size", uint64(len(b)), uint64(len(b) + 1)}
+			}
+			return nil, err
+		}
+		r.meta = meta
+		hsize = len(b) - br.Len()
+	}
+
+	size := uint64(hsize) + h.Count*uint64(r.itemSize) + 4
+	if h.Count > uint64(len(b))/uint64(r.itemSize) || uint64(len(b)) != size {
+		return nil, &FormatError{"size", uint64(len(b)), size}
+	}
+	r.count = int(h.Count)
+	r.items = b[hsize : len(b)-4]
+
+	sum, want := binary.LittleEndian.Uint32(b[len(b)-4:]), crc32.Checksum(b[:len(b)-4], crcTable)
+	if sum != want {
+		return nil, &FormatError{"checksum", uint64(sum), uint64(want)}
+	}
+
+	return r, nil
+}
+
+// Meta returns the metadata of the snapshot.
+func (r *SnapshotReader) Meta() Meta {
+	return r.meta
+}
+
+// Len returns the number of items in the snapshot.
+func (r *SnapshotReader) Len() int {
+	return r.count
+}
+
+func (r *SnapshotReader) key(i int) Key {
+	b := r.items[i*r.itemSize:]
+	x := uint64(0)
+	for j := r.keySize - 1; j >= 0; j-- {
+		x = x<<8 | uint64(b[j])
+	}
+	return Key(x)
+}
+
+// Search tries to retrieve the value associated with the given key.
+// If no such item is found, ok is set to false.
+func (r *SnapshotReader) Search(k Key) (v Value, ok bool) {
+	i := sort.Search(r.count, func(i int) bool { return r.key(i) >= k })
+	if i == r.count || r.key(i) != k {
+		return zero, false
+	}
+
+	var it item
+	binary.Read(bytes.NewReader(r.items[i*r.itemSize:(i+1)*r.itemSize]), binary.LittleEndian, &it)
+	return it.Value, true
+}
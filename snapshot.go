@@ -0,0 +1,223 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SnapshotStore keeps a sequence of snapshots (encodings, as written by WriteTo) of a hash map.
+// Snapshot names are chosen by the store and sort in the order the snapshots were saved.
+type SnapshotStore interface {
+	// Save writes a snapshot of c and returns its name.
+	Save(c *Cuckoo) (name string, err error)
+	// Load reads the snapshot called name into c, as ReadFrom does.
+	Load(name string, c *Cuckoo) error
+	// List returns the names of all snapshots, oldest first.
+	List() ([]string, error)
+	// Prune removes all but the keep most recent snapshots.
+	Prune(keep int) error
+}
+
+var (
+	_ SnapshotStore = (*DirStore)(nil)
+	_ SnapshotStore = (*KVStore)(nil)
+)
+
+const snapshotExt = ".ckoo"
+
+// snapshotName returns the name following the last of names (which must be sorted).
+func snapshotName(names []string) string {
+	seq := 0
+	if len(names) > 0 {
+		fmt.Sscanf(names[len(names)-1], "%d", &seq)
+	}
+	return fmt.Sprintf("%010d%s", seq+1, snapshotExt)
+}
+
+// prune returns the names to be deleted to keep the keep most recent ones.
+func prune(names []string, keep int) []string {
+	if keep < 0 {
+		keep = 0
+	}
+	if len(names) <= keep {
+		return nil
+	}
+	return names[:len(names)-keep]
+}
+
+// DirStore is a SnapshotStore keeping each snapshot in a file of a directory.
+// Snapshots are written to a temporary file first and renamed into place, so a crash never leaves a partial snapshot behind.
+type DirStore struct {
+	Dir string
+}
+
+// NewDirStore returns a DirStore keeping snapshots in dir, creating it if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirStore{Dir: dir}, nil
+}
+
+// Save writes a snapshot of c into a new file.
+func (s *DirStore) Save(c *Cuckoo) (string, error) {
+	names, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	name := snapshotName(names)
+
+	f, err := ioutil.TempFile(s.Dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly after the rename.
+
+	if _, err := c.WriteTo(f); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return name, os.Rename(f.Name(), filepath.Join(s.Dir, name))
+}
+
+// Load reads the snapshot called name into c.
+func (s *DirStore) Load(name string, c *Cuckoo) error {
+	f, err := os.Open(filepath.Join(s.Dir, filepath.Base(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = c.ReadFrom(f)
+	return err
+}
+
+// List returns the names of the snapshot files in the directory, oldest first.
+func (s *DirStore) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if name := e.Name(); e.Mode().IsRegular() && strings.HasSuffix(name, snapshotExt) && !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Prune deletes all but the keep most recent snapshot files.
+func (s *DirStore) Prune(keep int) error {
+	names, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range prune(names, keep) {
+		if err := os.Remove(filepath.Join(s.Dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KV is the minimal interface of a key-value store (such as a bolt bucket, a badger database, or an SQL table of blobs)
+// needed by KVStore. Adapting a particular store is left to the application, so this package has no dependencies.
+type KV interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Keys returns all keys starting with prefix, in any order.
+	Keys(prefix string) ([]string, error)
+}
+
+// KVStore is a SnapshotStore keeping each snapshot as a value of a KV, under Prefix followed by the snapshot name.
+type KVStore struct {
+	KV     KV
+	Prefix string
+}
+
+// Save writes a snapshot of c under a new key.
+func (s *KVStore) Save(c *Cuckoo) (string, error) {
+	names, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	name := snapshotName(names)
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		return "", err
+	}
+	return name, s.KV.Put(s.Prefix+name, buf.Bytes())
+}
+
+// Load reads the snapshot called name into c.
+func (s *KVStore) Load(name string, c *Cuckoo) error {
+	b, err := s.KV.Get(s.Prefix + name)
+	if err != nil {
+		return err
+	}
+	_, err = c.ReadFrom(bytes.NewReader(b))
+	return err
+}
+
+// List returns the names of the snapshots in the KV, oldest first.
+func (s *KVStore) List() ([]string, error) {
+	keys, err := s.KV.Keys(s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, key := range keys {
+		if name := strings.TrimPrefix(key, s.Prefix); strings.HasSuffix(name, snapshotExt) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Prune deletes all but the keep most recent snapshots from the KV.
+func (s *KVStore) Prune(keep int) error {
+	names, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range prune(names, keep) {
+		if err := s.KV.Delete(s.Prefix + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type memKV map[string][]byte
+
+func (m memKV) Get(key string) ([]byte, error) {
+	b, ok := m[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return b, nil
+}
+func (m memKV) Put(key string, value []byte) error { m[key] = value; return nil }
+func (m memKV) Delete(key string) error            { delete(m, key); return nil }
+func (m memKV) Keys(prefix string) ([]string, error) {
+	var keys []string
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func testSnapshotStore(t *testing.T, s SnapshotStore) {
+	c := NewCuckoo(DefaultLogSize)
+	var names []string
+	for i := 1; i <= 3; i++ {
+		c.Insert(Key(i), Value(i))
+		name, err := s.Save(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	if err := s.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0] != names[1] || list[1] != names[2] {
+		t.Error("got: ", list, " expected: ", names[1:])
+	}
+
+	d := NewCuckoo(DefaultLogSize)
+	if err := s.Load(names[1], d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != 2 {
+		t.Error("got: ", d.Len(), " expected: ", 2)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cuckoo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotStore(t, s)
+}
+
+func TestKVStore(t *testing.T) {
+	kv := memKV{"unrelated": nil}
+	testSnapshotStore(t, &KVStore{KV: kv, Prefix: "snap/"})
+	if _, ok := kv["unrelated"]; !ok {
+		t.Error("Prune removed an unrelated key")
+	}
+}
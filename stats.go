@@ -0,0 +1,219 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram is a latency histogram with logarithmic buckets: h[i] counts the operations which took [2^(i-1), 2^i) nanoseconds
+// (h[0] counts those which took under a nanosecond).
+type Histogram [65]uint64
+
+// Count returns the number of operations recorded.
+func (h *Histogram) Count() uint64 {
+	n := uint64(0)
+	for _, count := range h {
+		n += count
+	}
+	return n
+}
+
+// Quantile returns an upper bound for the q-th quantile (0 <= q <= 1) of the recorded latencies,
+// which is within a factor of 2 of the real value.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	rank := uint64(q * float64(h.Count()))
+	for i, count := range h {
+		if count > rank {
+			return time.Duration(uint64(1)<<uint(i) - 1)
+		}
+		rank -= count
+	}
+	return 0
+}
+
+// record is atomic, since Concurrent searches shards under a read lock.
+func (h *Histogram) record(d time.Duration) {
+	atomic.AddUint64(&h[bits.Len64(uint64(d))], 1)
+}
+
+func (h *Histogram) load() (l Histogram) {
+	for i := range h {
+		l[i] = atomic.LoadUint64(&h[i])
+	}
+	return
+}
+
+func (h *Histogram) add(other *Histogram) {
+	for i := range h {
+		h[i] += other[i]
+	}
+}
+
+type latencies struct {
+	insert, search, delete Histogram
+}
+
+// Stats holds statistics about a hash map, see Cuckoo.Stats.
+type Stats struct {
+	Len        int
+	Cap        int
+	LoadFactor float64
+	Grows      int // number of times the table grew.
+	Shrinks    int // number of times the table shrank.
+	Rehashes   int // number of times the table was rebuilt with new seeds but the same size.
+	Kicks      int // number of items evicted by random walks.
+	Rejected   int // number of items dropped due to the size limit.
+	MaxChain   int // longest displacement chain, see MaxChain.
+	Floods     int // number of insertions which failed at a load factor below floodThreshold, see SetFloodHandler.
+
+	// Latency histograms, recorded only when instrument is set in config.go.
+	Insert, Search, Delete Histogram
+}
+
+// Stats returns statistics about the hash map.
+func (c *Cuckoo) Stats() Stats {
+	s := Stats{
+		Len:        c.nentries,
+		Cap:        c.Cap(),
+		LoadFactor: c.LoadFactor(),
+		Grows:      c.ngrow,
+		Shrinks:    c.nshrink,
+		Rehashes:   c.nrehash,
+		Kicks:      c.nkicks,
+		Rejected:   c.nrejected,
+		MaxChain:   c.maxChain,
+		Floods:     c.nflood,
+	}
+	if c.lat != nil {
+		s.Insert, s.Search, s.Delete = c.lat.insert.load(), c.lat.search.load(), c.lat.delete.load()
+	}
+	return s
+}
+
+// add accumulates the statistics of another shard into s. LoadFactor is left for the caller to recompute.
+func (s *Stats) add(other *Stats) {
+	s.Len += other.Len
+	s.Cap += other.Cap
+	s.Grows += other.Grows
+	s.Shrinks += other.Shrinks
+	s.Rehashes += other.Rehashes
+	s.Kicks += other.Kicks
+	s.Rejected += other.Rejected
+	if other.MaxChain > s.MaxChain {
+		s.MaxChain = other.MaxChain
+	}
+	s.Floods += other.Floods
+	s.Insert.add(&other.Insert)
+	s.Search.add(&other.Search)
+	s.Delete.add(&other.Delete)
+}
+
+// Stats returns statistics about the hash map, summed over the shards.
+// Counters are kept per shard and only aggregated here, so collecting them never adds contention to other operations;
+// as with Len, shards are visited one after the other.
+func (m *Concurrent) Stats() Stats {
+	var s Stats
+	for _, ss := range m.ShardStats() {
+		s.add(&ss)
+	}
+	if s.Cap > 0 {
+		s.LoadFactor = float64(s.Len) / float64(s.Cap)
+	}
+	return s
+}
+
+// Placement returns, for each hash function, the number of items in the bucket it selects. Items in the stash and
+// the item with key 0 are not counted, and an item whose bucket is selected by several functions counts for the first one.
+// Since Insert tries the hash functions in order, the counts normally decrease smoothly from the first to the last one;
+// a function with far fewer items than the next one hints at a hash function which is weak for the current keys (see SetHash).
+// This rehashes every item in the table.
+func (c *Cuckoo) Placement() [NumHash]int {
+	var counts [NumHash]int
+	var h [nhash]hash
+	for bi := range c.buckets {
+		for _, k := range &c.buckets[bi].keys {
+			if k == 0 {
+				continue
+			}
+			c.dohash(k, &h)
+			for i, hval := range &h {
+				if int(hval) == bi {
+					counts[i]++
+					break
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// SetSkewHandler registers f to be called with the placement counts (see Placement) whenever the table has been rebuilt,
+// by a grow or a rehash, and some hash function places fewer than 1/ratio times as many items as the next one
+// (unless the next one places fewer than skewMin items, see config.go, which happens by chance in small tables).
+// This points to a hash function which is weak for the current keys; f can log it, or switch to another one with SetHash
+// once Insert has returned. f must not modify the hash map. Checking rehashes every item once more, on top of the rebuild.
+// Passing a nil f removes the handler.
+func (c *Cuckoo) SetSkewHandler(ratio float64, f func(counts [NumHash]int)) {
+	c.skewRatio = ratio
+	c.onSkew = f
+}
+
+// checkSkew calls the skew handler if the placement counts are skewed, see SetSkewHandler.
+func (c *Cuckoo) checkSkew() {
+	if c.onSkew == nil {
+		return
+	}
+	counts := c.Placement()
+	for i := 0; i+1 < len(counts); i++ {
+		if counts[i+1] >= skewMin && float64(counts[i])*c.skewRatio < float64(counts[i+1]) {
+			c.onSkew(counts)
+			return
+		}
+	}
+}
+
+// ShardStats returns the statistics of each shard separately, for spotting an imbalance between them.
+func (m *Concurrent) ShardStats() []Stats {
+	stats := make([]Stats, len(m.shards))
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.RLock()
+		stats[i] = sh.c.Stats()
+		sh.RUnlock()
+	}
+	return stats
+}
+
+// ResetLatencies clears the latency histograms.
+func (c *Cuckoo) ResetLatencies() {
+	if c.lat != nil {
+		*c.lat = latencies{}
+	}
+}
+
+func (c *Cuckoo) stats() *latencies {
+	if c.lat == nil {
+		c.lat = &latencies{}
+	}
+	return c.lat
+}
+
+func (c *Cuckoo) latency(h *Histogram, start time.Time) {
+	h.record(time.Since(start))
+}
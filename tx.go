@@ -0,0 +1,156 @@
+// Copyright (c) 2014-2015 Utkan Güngördü <utkan@freeconsole.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"errors"
+)
+
+var (
+	// ErrTxFull is returned by Tx.Commit when an insertion was dropped because the table is full at its size limit.
+	ErrTxFull = errors.New("cuckoo: transaction does not fit in the hash map")
+	// ErrTxRollback is returned by Tx.Commit when rolling back a failed transaction dropped items itself, see Commit.
+	ErrTxRollback = errors.New("cuckoo: transaction rollback dropped items")
+	// ErrTxDone is returned when a transaction is used after Commit or Abort.
+	ErrTxDone = errors.New("cuckoo: transaction has already been committed or aborted")
+)
+
+// Tx collects insertions and deletions and applies them to a hash map all at once, or not at all.
+// The hash map must not be modified by other means between Begin and Commit.
+type Tx struct {
+	c    *Cuckoo
+	ops  []txOp
+	done bool
+}
+
+type txOp struct {
+	k      Key
+	v      Value
+	delete bool
+}
+
+// undo restores an item to its state before a transaction.
+type undo struct {
+	k      Key
+	v      Value
+	exists bool
+}
+
+// Begin starts a transaction on c.
+func (c *Cuckoo) Begin() *Tx {
+	return &Tx{c: c}
+}
+
+// Insert records the insertion of k/v, to be applied by Commit.
+func (tx *Tx) Insert(k Key, v Value) {
+	tx.ops = append(tx.ops, txOp{k: k, v: v})
+}
+
+// Delete records the deletion of k, to be applied by Commit.
+func (tx *Tx) Delete(k Key) {
+	tx.ops = append(tx.ops, txOp{k: k, delete: true})
+}
+
+// Search looks k up as if the transaction was already committed.
+func (tx *Tx) Search(k Key) (v Value, ok bool) {
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		if op := &tx.ops[i]; op.k == k {
+			return op.v, !op.delete
+		}
+	}
+	return tx.c.Search(k)
+}
+
+// Abort discards the transaction, leaving the hash map untouched.
+func (tx *Tx) Abort() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.ops = nil
+	return nil
+}
+
+// Commit applies the recorded operations in order. If an insertion is dropped because the table cannot grow
+// (see SetMaxLogSize and SetGrowHandler), every operation is rolled back, including items evicted under EvictRandom,
+// and ErrTxFull is returned. The evict handler only sees evictions of committed transactions.
+//
+// Rolling back re-inserts items, which can itself fail at the size limit, though very rarely. Such items are handled
+// like any item dropped by Insert: they are passed to the evict handler and counted by Rejected, and ErrTxRollback is returned.
+//
+// Note that this is atomicity in memory only: nothing is persisted, so it does not protect against a crash of the process.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	c := tx.c
+	onEvict := c.onEvict
+	var evicted []item
+	c.onEvict = func(k Key, v Value) { evicted = append(evicted, item{k, v}) }
+	defer func() { c.onEvict = onEvict }()
+
+	undos := make([]undo, 0, len(tx.ops))
+	nrejected := c.nrejected
+	for _, op := range tx.ops {
+		v, ok := c.Search(op.k)
+		undos = append(undos, undo{op.k, v, ok})
+
+		if op.delete {
+			c.Delete(op.k)
+			continue
+		}
+
+		c.Insert(op.k, op.v)
+		if c.nrejected != nrejected {
+			break
+		}
+	}
+
+	if c.nrejected == nrejected {
+		if onEvict != nil {
+			for _, it := range evicted {
+				onEvict(it.Key, it.Value)
+			}
+		}
+		return nil
+	}
+
+	// the rollback runs with the caller's evict handler and counts its own drops, so that none goes unnoticed.
+	c.onEvict = onEvict
+	c.nrejected = nrejected
+	touched := make(map[Key]bool, len(undos))
+	for i := len(undos) - 1; i >= 0; i-- {
+		u := &undos[i]
+		touched[u.k] = true
+		if u.exists {
+			c.Insert(u.k, u.v)
+		} else {
+			c.Delete(u.k)
+		}
+	}
+	for _, it := range evicted {
+		// evicted items touched by the transaction are already restored above.
+		if !touched[it.Key] {
+			c.Insert(it.Key, it.Value)
+		}
+	}
+	if c.nrejected != nrejected {
+		return ErrTxRollback
+	}
+	return ErrTxFull
+}